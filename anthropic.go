@@ -19,13 +19,18 @@ import (
 	"errors"
 	"fmt"
 	"iter"
+	"maps"
 	"math/rand/v2"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/anthropics/anthropic-sdk-go/packages/respjson"
 	"github.com/anthropics/anthropic-sdk-go/vertex"
 	"google.golang.org/genai"
 
@@ -52,6 +57,73 @@ type anthropicModel struct {
 	defaultMaxTokens int
 	promptCaching    *PromptCachingConfig
 
+	// Stream watchdog limits; zero disables the corresponding phase.
+	streamFirstEventTimeout time.Duration
+	streamIdleTimeout       time.Duration
+
+	// streamCoalescing buffers streamed deltas when non-nil.
+	streamCoalescing *StreamCoalescingConfig
+
+	// refusalAsError turns a refusal stop into a *RefusalError.
+	refusalAsError bool
+
+	// maxPauseTurnContinuations bounds automatic pause_turn follow-ups;
+	// zero disables them.
+	maxPauseTurnContinuations int
+
+	// forceStreaming sends non-streaming requests over a stream even when
+	// the SDK would accept them as plain requests.
+	forceStreaming bool
+
+	// maxTokensContinuation continues text truncated at max_tokens when
+	// non-nil.
+	maxTokensContinuation *MaxTokensContinuationConfig
+
+	// fileDataInliner downloads FileData URIs to send them inline when
+	// non-nil.
+	fileDataInliner *converters.FileDataInliner
+
+	// imageNormalization preprocesses inline images when non-nil.
+	imageNormalization *converters.ImageNormalization
+
+	// textExtractor reads office and other documents as plain text when
+	// non-nil.
+	textExtractor converters.TextExtractor
+
+	// documentCitations enables citations on every document block.
+	documentCitations bool
+
+	// toolErrorDetector marks failed tool results; nil means the
+	// converters default.
+	toolErrorDetector converters.ToolErrorDetector
+
+	// toolResultUnwrapping sends textual tool results as raw text when
+	// non-nil.
+	toolResultUnwrapping *converters.ToolResultUnwrapping
+
+	// historySanitizer repairs unpaired tool calls and results when
+	// non-nil.
+	historySanitizer *converters.HistorySanitizer
+
+	// strictRoles rejects content roles other than user, model and
+	// assistant.
+	strictRoles bool
+
+	// assistantPrefill sends a trailing model text turn as a prefill.
+	assistantPrefill bool
+
+	// continuationPrompts overrides the added user message texts when
+	// non-nil.
+	continuationPrompts *ContinuationPromptsConfig
+
+	// historyTrimmer shortens the conversation before conversion when
+	// non-nil.
+	historyTrimmer converters.HistoryTrimmer
+
+	// contextManagement enables Anthropic's server-side context editing
+	// when non-nil.
+	contextManagement *ContextManagementConfig
+
 	// retrySleep waits between mid-stream overload retries. Overridable so
 	// tests can drop the delay; production always gets sleepWithContext.
 	retrySleep func(ctx context.Context, d time.Duration) error
@@ -77,6 +149,10 @@ func NewModel(ctx context.Context, modelName anthropic.Model, cfg *Config) (mode
 		variant = GetVariant()
 	}
 
+	if err := cfg.ContextManagement.validate(); err != nil {
+		return nil, err
+	}
+
 	var client anthropic.Client
 
 	switch variant {
@@ -112,15 +188,74 @@ func NewModel(ctx context.Context, modelName anthropic.Model, cfg *Config) (mode
 	}
 
 	return &anthropicModel{
-		client:           client,
-		name:             modelName,
-		variant:          variant,
-		defaultMaxTokens: maxTokens,
-		promptCaching:    cfg.PromptCaching,
-		retrySleep:       sleepWithContext,
+		client:                    client,
+		name:                      modelName,
+		variant:                   variant,
+		defaultMaxTokens:          maxTokens,
+		promptCaching:             cfg.PromptCaching,
+		streamFirstEventTimeout:   cfg.StreamFirstEventTimeout,
+		streamIdleTimeout:         cfg.StreamIdleTimeout,
+		streamCoalescing:          cfg.StreamCoalescing,
+		refusalAsError:            cfg.RefusalAsError,
+		maxPauseTurnContinuations: cfg.MaxPauseTurnContinuations,
+		forceStreaming:            cfg.ForceStreaming,
+		maxTokensContinuation:     cfg.MaxTokensContinuation,
+		fileDataInliner:           newFileDataInliner(cfg.FileDataInlining, variant, cfg.ImageNormalization != nil),
+		imageNormalization:        newImageNormalization(cfg.ImageNormalization),
+		textExtractor:             cfg.TextExtractor,
+		documentCitations:         cfg.DocumentCitations,
+		toolErrorDetector:         cfg.ToolErrorDetector,
+		toolResultUnwrapping:      cfg.ToolResultUnwrapping,
+		historySanitizer:          cfg.HistorySanitizer,
+		strictRoles:               cfg.StrictRoles,
+		assistantPrefill:          cfg.AssistantPrefill,
+		continuationPrompts:       cfg.ContinuationPrompts,
+		historyTrimmer:            cfg.HistoryTrimmer,
+		contextManagement:         cfg.ContextManagement,
+		retrySleep:                sleepWithContext,
 	}, nil
 }
 
+// newFileDataInliner returns the inliner cfg configures for variant, or nil
+// when FileData URIs should be passed through as URL sources. With image
+// normalization, the inliner accepts every image type for it to convert.
+func newFileDataInliner(cfg *FileDataInliningConfig, variant string, normalizeImages bool) *converters.FileDataInliner {
+	if cfg == nil || cfg.Policies[variant] == URLSourcePassThrough {
+		return nil
+	}
+	fetcher := cfg.Fetcher
+	if fetcher == nil {
+		fetcher = converters.SchemeBlobFetcher{
+			"http":  converters.HTTPBlobFetcher{},
+			"https": converters.HTTPBlobFetcher{},
+		}
+	}
+	var cache *converters.BlobCache
+	switch {
+	case cfg.CacheBytes == 0:
+		cache = converters.NewBlobCache(converters.DefaultBlobCacheBytes)
+	case cfg.CacheBytes > 0:
+		cache = converters.NewBlobCache(cfg.CacheBytes)
+	}
+	return &converters.FileDataInliner{
+		Fetcher:      fetcher,
+		MaxBytes:     cfg.MaxBytes,
+		Cache:        cache,
+		AnyImageType: normalizeImages,
+	}
+}
+
+// newImageNormalization returns cfg with a DefaultImageCacheBytes cache when
+// it has none, so history images are not re-encoded on every request.
+func newImageNormalization(cfg *converters.ImageNormalization) *converters.ImageNormalization {
+	if cfg == nil || cfg.Cache != nil {
+		return cfg
+	}
+	n := *cfg
+	n.Cache = converters.NewImageCache(converters.DefaultImageCacheBytes)
+	return &n
+}
+
 // newAPIClient creates a client for the direct Anthropic API.
 func newAPIClient(cfg *Config) anthropic.Client {
 	opts := []option.RequestOption{}
@@ -165,84 +300,253 @@ func (m *anthropicModel) Name() string {
 
 // GenerateContent calls the Anthropic model.
 func (m *anthropicModel) GenerateContent(ctx context.Context, req *model.LLMRequest, stream bool) iter.Seq2[*model.LLMResponse, error] {
+	// Trim before the continuation prompt is appended, so the trimmer
+	// sees the real last turn.
+	var trimmed *converters.TrimmedHistory
+	req.Contents, trimmed = converters.TrimContents(m.historyTrimmer, req.Contents)
 	m.maybeAppendUserContent(req)
 
 	if stream {
-		return m.generateStream(ctx, req)
+		return m.generateStream(ctx, req, trimmed)
 	}
 
 	return func(yield func(*model.LLMResponse, error) bool) {
-		resp, err := m.generate(ctx, req)
+		resp, err := m.generate(ctx, req, trimmed)
 		yield(resp, err)
 	}
 }
 
-// generate calls the model synchronously.
-func (m *anthropicModel) generate(ctx context.Context, req *model.LLMRequest) (*model.LLMResponse, error) {
-	params, err := m.convertRequest(req)
+// generate calls the model synchronously. trimmed describes the contents the
+// history trimmer dropped, for the response metadata.
+func (m *anthropicModel) generate(ctx context.Context, req *model.LLMRequest, trimmed *converters.TrimmedHistory) (*model.LLMResponse, error) {
+	req, err := m.inlineFileData(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert request: %w", err)
+	}
+	params, report, err := m.convertRequest(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert request: %w", err)
 	}
 
-	msg, err := m.client.Messages.New(ctx, params)
+	// httpResp holds the HTTP response of the latest request, for the
+	// request id and rate-limit headers.
+	var httpResp *http.Response
+	msg, err := m.newMessage(ctx, params, &httpResp)
 	if err != nil {
-		return nil, fmt.Errorf("failed to call model: %w", err)
+		return nil, fmt.Errorf("failed to call model: %w", classifyAPIError(err))
+	}
+
+	var continuations continuationCounter
+	for {
+		cont, ok := m.nextContinuation(params, msg, &continuations)
+		if !ok {
+			break
+		}
+		lastResp := httpResp
+		next, err := m.newMessage(ctx, cont.params, &httpResp)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("failed to continue response: %w", classifyAPIError(err))
+			}
+			// The response so far is what the caller would have got
+			// without continuation; return it rather than the error.
+			httpResp = lastResp
+			break
+		}
+		msg = cont.merge(msg, next)
+	}
+
+	// A tool call cut off at max_tokens would otherwise fail conversion
+	// with an opaque unmarshal error; report it exactly as the streaming
+	// path does.
+	if interrupted := truncatedToolCallError(msg); interrupted != nil {
+		return nil, interrupted
+	}
+
+	if m.refusalAsError && msg.StopReason == anthropic.StopReasonRefusal {
+		return nil, newRefusalError(msg)
 	}
 
 	resp, err := converters.MessageToLLMResponse(msg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert response: %w", err)
 	}
+	m.addResponseMetadata(resp, httpResp)
+	addConvertMetadata(resp, report)
+	addTrimMetadata(resp, trimmed)
 
 	return resp, nil
 }
 
+// newMessage sends params as a non-streaming request. When the SDK would
+// refuse it because the output may take longer than a single HTTP response
+// allows, or when forceStreaming is set, it sends a streaming request instead
+// and returns the accumulated message, so callers of generate see the same
+// non-streaming contract either way. The HTTP response is stored in httpResp.
+func (m *anthropicModel) newMessage(ctx context.Context, params anthropic.MessageNewParams, httpResp **http.Response) (*anthropic.Message, error) {
+	if !m.forceStreaming {
+		if _, err := anthropic.CalculateNonStreamingTimeout(int(params.MaxTokens), params.Model, m.client.Options); err == nil {
+			return m.client.Messages.New(ctx, params, requestOptions(params, httpResp)...)
+		}
+	}
+
+	// Reuse the streaming machinery (retries, watchdog) but discard the
+	// partials: only the accumulated message or the first error matters.
+	var streamErr error
+	message := m.streamWithRetry(ctx, params, httpResp, func(_ *model.LLMResponse, err error) bool {
+		if err != nil {
+			streamErr = err
+			return false
+		}
+		return true
+	})
+	if message == nil {
+		if streamErr == nil {
+			streamErr = errors.New("stream ended without a message")
+		}
+		return nil, streamErr
+	}
+	return message, nil
+}
+
 // generateStream returns a stream of responses from the model.
-func (m *anthropicModel) generateStream(ctx context.Context, req *model.LLMRequest) iter.Seq2[*model.LLMResponse, error] {
+func (m *anthropicModel) generateStream(ctx context.Context, req *model.LLMRequest, trimmed *converters.TrimmedHistory) iter.Seq2[*model.LLMResponse, error] {
 	return func(yield func(*model.LLMResponse, error) bool) {
-		params, err := m.convertRequest(req)
+		req, err := m.inlineFileData(ctx, req)
+		if err != nil {
+			yield(nil, fmt.Errorf("failed to convert request: %w", err))
+			return
+		}
+		params, report, err := m.convertRequest(req)
 		if err != nil {
 			yield(nil, fmt.Errorf("failed to convert request: %w", err))
 			return
 		}
 
-		// Retry mid-stream overloads, but only while nothing has been yielded:
-		// once a delta has reached the consumer, a retry would replay content
-		// it already has, so streamOnce handles those failures terminally and
-		// returns nil. This is a deliberate, narrow exception to the adapter's
-		// "no continuation decisions" rule — a pre-content retry is invisible
-		// to callers and carries no continuation semantics.
-		for attempt := 1; ; attempt++ {
-			streamErr := m.streamOnce(ctx, params, yield)
-			if streamErr == nil {
-				return
-			}
-			if attempt == streamMaxAttempts || !isOverloadedStreamError(streamErr) {
-				// Same wrap as before the retry existed, so caller-side
-				// handling and error grouping stay identical on exhaustion.
-				yield(nil, fmt.Errorf("stream error: %w", streamErr))
-				return
+		var httpResp *http.Response
+		segment := newStreamSegment(yield)
+		message := m.streamWithRetry(ctx, params, &httpResp, segment.wrap)
+		if message == nil {
+			return
+		}
+
+		// Continue paused or truncated turns as further requests on the
+		// same stream: the consumer sees one uninterrupted run of deltas
+		// and a single final response covering every segment.
+		var continuations continuationCounter
+		for {
+			cont, ok := m.nextContinuation(params, message, &continuations)
+			if !ok {
+				if !segment.finish() {
+					return
+				}
+				break
 			}
-			if err := m.retrySleep(ctx, streamRetryDelay(attempt)); err != nil {
-				// Cancelled during backoff: wrap the overload and the
-				// cancellation together, so callers that filter caller
-				// cancellations (errors.Is) and callers that detect overload
-				// (errors.As) both still match.
-				yield(nil, fmt.Errorf("stream error: %w (retry aborted: %w)", streamErr, err))
-				return
+			lastResp := httpResp
+			segment = segment.next(cont.wrapYield(yield), message)
+			next := m.streamWithRetry(ctx, cont.params, &httpResp, segment.wrap)
+			if next == nil {
+				if segment.err == nil {
+					return
+				}
+				if ctx.Err() != nil {
+					yield(nil, segment.err)
+					return
+				}
+				// The continuation failed before streaming anything, so
+				// the stream can still end with the response so far.
+				httpResp = lastResp
+				if !segment.abandon() {
+					return
+				}
+				break
 			}
+			message = cont.merge(message, next)
+		}
+
+		m.finishStream(message, httpResp, report, trimmed, yield)
+	}
+}
+
+// streamWithRetry streams one request, retrying failures that occur before
+// any content reached the consumer. It returns the accumulated message once
+// the stream completes, or nil when the outcome has already been yielded.
+// The HTTP response of the latest attempt is stored in httpResp.
+func (m *anthropicModel) streamWithRetry(ctx context.Context, params anthropic.MessageNewParams, httpResp **http.Response, yield func(*model.LLMResponse, error) bool) *anthropic.Message {
+	// Retry mid-stream overloads and watchdog stalls, but only while
+	// nothing has been yielded: once a delta has reached the consumer, a
+	// retry would replay content it already has, so streamOnce handles
+	// those failures terminally. This is a deliberate, narrow exception to
+	// the adapter's "no continuation decisions" rule — a pre-content retry
+	// is invisible to callers and carries no continuation semantics.
+	for attempt := 1; ; attempt++ {
+		message, streamErr := m.streamOnce(ctx, params, httpResp, yield)
+		if streamErr == nil {
+			return message
+		}
+		if attempt == streamMaxAttempts || !isRetryableStreamError(streamErr) {
+			// Same wrap as before the retry existed, so caller-side
+			// handling and error grouping stay identical on exhaustion.
+			yield(nil, fmt.Errorf("stream error: %w", classifyAPIError(streamErr)))
+			return nil
+		}
+		if err := m.retrySleep(ctx, streamRetryDelay(attempt)); err != nil {
+			// Cancelled during backoff: wrap the overload and the
+			// cancellation together, so callers that filter caller
+			// cancellations (errors.Is) and callers that detect overload
+			// (errors.As) both still match.
+			yield(nil, fmt.Errorf("stream error: %w (retry aborted: %w)", classifyAPIError(streamErr), err))
+			return nil
 		}
 	}
 }
 
-// streamOnce runs a single streaming attempt, yielding partial deltas and the
-// final response. It returns a non-nil error only when the stream failed
-// before any partial content reached the consumer — the one window in which
-// generateStream may safely retry without duplicating output. Every other
-// outcome (success, consumer stop, post-content failure, interruption) is
-// fully handled here and signalled by a nil return.
-func (m *anthropicModel) streamOnce(ctx context.Context, params anthropic.MessageNewParams, yield func(*model.LLMResponse, error) bool) error {
-	stream := m.client.Messages.NewStreaming(ctx, params)
+// finishStream yields the final response for a completed stream, or the
+// typed error that replaces it.
+func (m *anthropicModel) finishStream(message *anthropic.Message, httpResp *http.Response, report *converters.ConvertReport, trimmed *converters.TrimmedHistory, yield func(*model.LLMResponse, error) bool) {
+	// Belt-and-braces: the stream can complete without Accumulate erroring
+	// yet still carry a tool call truncated at the ceiling (invalid input
+	// JSON). Converting that normally would fail or emit a broken tool
+	// call, so report the interruption instead.
+	if interrupted := truncatedToolCallError(message); interrupted != nil {
+		yield(nil, interrupted)
+		return
+	}
+
+	if m.refusalAsError && message.StopReason == anthropic.StopReasonRefusal {
+		yield(nil, newRefusalError(message))
+		return
+	}
+
+	// Yield the final complete response
+	finalResp, err := converters.MessageToLLMResponse(message)
+	if err != nil {
+		yield(nil, fmt.Errorf("failed to convert stream response: %w", err))
+		return
+	}
+	finalResp.TurnComplete = true
+	m.addResponseMetadata(finalResp, httpResp)
+	addConvertMetadata(finalResp, report)
+	addTrimMetadata(finalResp, trimmed)
+	yield(finalResp, nil)
+}
+
+// streamOnce runs a single streaming attempt, yielding partial deltas. When
+// the stream completes it returns the accumulated message for the caller to
+// finish. It returns a non-nil error only when the stream failed before any
+// partial content reached the consumer — the one window in which
+// streamWithRetry may safely retry without duplicating output. Every other
+// outcome (consumer stop, post-content failure, interruption) is fully
+// handled here and signalled by a nil message and nil error.
+func (m *anthropicModel) streamOnce(ctx context.Context, params anthropic.MessageNewParams, httpResp **http.Response, yield func(*model.LLMResponse, error) bool) (*anthropic.Message, error) {
+	// The watchdog cancels streamCtx, with a *StreamStalledError as the
+	// cause, when the stream goes quiet for longer than configured.
+	streamCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	watchdog := newStreamWatchdog(cancel, m.streamFirstEventTimeout, m.streamIdleTimeout)
+	watchdog.arm()
+
+	stream := m.client.Messages.NewStreaming(streamCtx, params, requestOptions(params, httpResp)...)
 	// Next() leaves the response body open on the SSE error-event and
 	// consumer-stop paths; without this, each retried attempt would leak its
 	// predecessor's connection. Close is nil-safe when the request itself
@@ -254,8 +558,23 @@ func (m *anthropicModel) streamOnce(ctx context.Context, params anthropic.Messag
 	// True once any delta has been yielded — the point of no return for
 	// retries.
 	yielded := false
-
-	for stream.Next() {
+	emit := func(resp *model.LLMResponse) bool {
+		yielded = true
+		return yield(resp, nil)
+	}
+	coalescer := newDeltaCoalescer(m.streamCoalescing)
+
+	// The coalescer flushes buffered deltas that come due while the stream
+	// waits for its next event.
+	advance := func() bool { return watchdog.next(stream.Next) }
+	for {
+		more, ok := coalescer.next(advance, emit, func() { cancel(nil) })
+		if !ok {
+			return nil, nil
+		}
+		if !more {
+			break
+		}
 		event := stream.Current()
 
 		// Accumulate the message. A failure here is almost always the
@@ -265,61 +584,106 @@ func (m *anthropicModel) streamOnce(ctx context.Context, params anthropic.Messag
 		// accumulation failure keeps its original error so it isn't
 		// misdiagnosed as an interruption.
 		if err := message.Accumulate(event); err != nil {
-			yield(nil, classifyAccumulateError(&message, err))
-			return nil
+			if coalescer.flush(emit) {
+				yield(nil, classifyAccumulateError(&message, err))
+			}
+			return nil, nil
 		}
 
 		// Handle different event types for streaming
 		switch ev := event.AsAny().(type) {
+		case anthropic.MessageStartEvent, anthropic.MessageDeltaEvent:
+			// Accumulate doesn't carry over stop_details; a refusal's
+			// category and explanation arrive only on message_delta. Nor
+			// does it carry context_management, which the SDK doesn't
+			// model yet.
+			if delta, ok := ev.(anthropic.MessageDeltaEvent); ok {
+				message.StopDetails = delta.Delta.StopDetails
+				if field, ok := delta.JSON.ExtraFields[converters.ContextManagementField]; ok {
+					extra := maps.Clone(message.JSON.ExtraFields)
+					if extra == nil {
+						extra = make(map[string]respjson.Field)
+					}
+					extra[converters.ContextManagementField] = field
+					message.JSON.ExtraFields = extra
+				}
+			}
+			// Usage and stop-reason updates carry no content, so they don't
+			// close the retry window: a retried attempt simply reports its
+			// own message_start.
+			if !coalescer.flush(emit) || !yield(converters.StreamMetadataToPartialResponse(&message), nil) {
+				return nil, nil
+			}
+		case anthropic.ContentBlockStartEvent:
+			// Block markers carry no content, so like usage partials they
+			// don't close the retry window.
+			if !coalescer.flush(emit) || !yield(converters.StreamBlockStartToPartialResponse(ev.Index, ev.ContentBlock.Type), nil) {
+				return nil, nil
+			}
 		case anthropic.ContentBlockDeltaEvent:
 			// Handle text deltas
 			switch delta := ev.Delta.AsAny().(type) {
 			case anthropic.TextDelta:
-				yielded = true
-				resp := converters.StreamDeltaToPartialResponse(delta.Text)
-				if !yield(resp, nil) {
-					return nil
+				if !coalescer.add(delta.Text, false, ev.Index, emit) {
+					return nil, nil
 				}
 			case anthropic.ThinkingDelta:
-				yielded = true
-				resp := converters.StreamThinkingDeltaToPartialResponse(delta.Thinking)
-				if !yield(resp, nil) {
-					return nil
+				if !coalescer.add(delta.Thinking, true, ev.Index, emit) {
+					return nil, nil
+				}
+			}
+		case anthropic.ContentBlockStopEvent:
+			if !coalescer.flush(emit) {
+				return nil, nil
+			}
+			// The accumulated block is complete now, including a thinking
+			// block's signature.
+			if ev.Index >= 0 && ev.Index < int64(len(message.Content)) {
+				if !yield(converters.StreamBlockStopToPartialResponse(ev.Index, message.Content[ev.Index]), nil) {
+					return nil, nil
 				}
 			}
 		}
 	}
 
 	if err := stream.Err(); err != nil {
+		// Report a watchdog stall rather than the bare cancellation it caused.
+		stalled := stalledCause(ctx, streamCtx)
+		if stalled != nil {
+			err = stalled
+		}
+		// Pre-content failure: streamWithRetry decides whether to retry.
+		// Deltas still buffered by the coalescer are dropped with the
+		// attempt; a failure that won't be retried delivers them first.
+		if !yielded && isRetryableStreamError(err) {
+			return nil, err
+		}
+		if !coalescer.flush(emit) {
+			return nil, nil
+		}
 		if !yielded {
-			// Pre-content failure: generateStream decides whether to retry.
-			return err
+			return nil, err
 		}
-		yield(nil, fmt.Errorf("stream error: %w", err))
-		return nil
+		if stalled != nil {
+			stalled.salvageStalled(&message)
+			yield(nil, stalled)
+			return nil, nil
+		}
+		yield(nil, fmt.Errorf("stream error: %w", classifyAPIError(err)))
+		return nil, nil
 	}
 
-	// Belt-and-braces: the stream can complete without Accumulate erroring
-	// yet still carry a tool call truncated at the ceiling (invalid input
-	// JSON). Converting that normally would fail or emit a broken tool
-	// call, so report the interruption instead. A max_tokens stop with an
-	// otherwise-valid message (e.g. truncated mid-thinking) is NOT an
-	// interruption for our purposes — it converts normally below and the
-	// harness reacts off the mapped max_tokens FinishReason.
-	if message.StopReason == anthropic.StopReasonMaxTokens && converters.HasIncompleteToolInput(&message) {
-		yield(nil, newOutputInterruptedError(&message, nil))
-		return nil
+	if !coalescer.flush(emit) {
+		return nil, nil
 	}
+	return &message, nil
+}
 
-	// Yield the final complete response
-	finalResp, err := converters.MessageToLLMResponse(&message)
-	if err != nil {
-		yield(nil, fmt.Errorf("failed to convert stream response: %w", err))
-		return nil
-	}
-	finalResp.TurnComplete = true
-	yield(finalResp, nil)
-	return nil
+// isRetryableStreamError reports whether a pre-content stream failure may be
+// retried: a mid-stream overload or a watchdog stall.
+func isRetryableStreamError(err error) bool {
+	var stalled *StreamStalledError
+	return isOverloadedStreamError(err) || errors.As(err, &stalled)
 }
 
 // isOverloadedStreamError reports whether err is Anthropic's overloaded_error
@@ -357,11 +721,38 @@ func sleepWithContext(ctx context.Context, d time.Duration) error {
 	}
 }
 
-// convertRequest converts an LLMRequest to Anthropic MessageNewParams.
-func (m *anthropicModel) convertRequest(req *model.LLMRequest) (anthropic.MessageNewParams, error) {
-	messages, err := converters.ContentsToMessages(req.Contents)
+// inlineFileData returns req with its image and PDF FileData downloaded and
+// inlined when the model is configured to. The contents are copied, not
+// modified, because they are shared with the session history.
+func (m *anthropicModel) inlineFileData(ctx context.Context, req *model.LLMRequest) (*model.LLMRequest, error) {
+	if m.fileDataInliner == nil {
+		return req, nil
+	}
+	contents, err := m.fileDataInliner.InlineContents(ctx, req.Contents)
+	if err != nil {
+		return nil, err
+	}
+	inlined := *req
+	inlined.Contents = contents
+	return &inlined, nil
+}
+
+// convertRequest converts an LLMRequest to Anthropic MessageNewParams. The
+// report describes what the content conversion changed, for the response
+// metadata.
+func (m *anthropicModel) convertRequest(req *model.LLMRequest) (anthropic.MessageNewParams, *converters.ConvertReport, error) {
+	messages, report, err := converters.ContentsToMessagesWithOptions(req.Contents, converters.ConvertOptions{
+		ImageNormalization:   m.imageNormalization,
+		TextExtractor:        m.textExtractor,
+		Citations:            m.documentCitations,
+		ToolErrorDetector:    m.toolErrorDetector,
+		ToolResultUnwrapping: m.toolResultUnwrapping,
+		HistorySanitizer:     m.historySanitizer,
+		StrictRoles:          m.strictRoles,
+		ContinuationPrompt:   m.continuationPrompts.withDefaults().ThinkingBoundary,
+	})
 	if err != nil {
-		return anthropic.MessageNewParams{}, fmt.Errorf("failed to convert contents: %w", err)
+		return anthropic.MessageNewParams{}, nil, fmt.Errorf("failed to convert contents: %w", err)
 	}
 
 	params := anthropic.MessageNewParams{
@@ -402,7 +793,7 @@ func (m *anthropicModel) convertRequest(req *model.LLMRequest) (anthropic.Messag
 		if req.Config.ToolConfig != nil {
 			toolChoice, err := converters.ToolConfigToToolChoice(req.Config.ToolConfig)
 			if err != nil {
-				return anthropic.MessageNewParams{}, err
+				return anthropic.MessageNewParams{}, nil, err
 			}
 			params.ToolChoice = toolChoice
 		}
@@ -413,7 +804,7 @@ func (m *anthropicModel) convertRequest(req *model.LLMRequest) (anthropic.Messag
 		if req.Config.ResponseSchema != nil {
 			schemaMap, err := converters.SchemaToStructuredOutputMap(req.Config.ResponseSchema)
 			if err != nil {
-				return anthropic.MessageNewParams{}, fmt.Errorf("failed to transform response schema: %w", err)
+				return anthropic.MessageNewParams{}, nil, fmt.Errorf("failed to transform response schema: %w", err)
 			}
 			params.OutputConfig = anthropic.OutputConfigParam{
 				Format: anthropic.JSONOutputFormatParam{
@@ -456,24 +847,151 @@ func (m *anthropicModel) convertRequest(req *model.LLMRequest) (anthropic.Messag
 		params.OutputConfig.Effort = ""
 	}
 
+	// A trailing assistant message is a prefill (see AssistantPrefill),
+	// which Anthropic does not accept alongside extended thinking.
+	if n := len(params.Messages); n > 0 && params.Messages[n-1].Role == anthropic.MessageParamRoleAssistant {
+		params.Thinking = anthropic.ThinkingConfigParamUnion{}
+		params.OutputConfig.Effort = ""
+	}
+
+	// System-role contents follow the system instruction, in order.
+	params.System = append(params.System, report.System...)
+
+	m.applyContextManagement(&params)
+
 	if m.promptCaching != nil {
 		applyCacheBreakpoints(&params, m.promptCaching)
 	}
 
-	return params, nil
+	return params, report, nil
 }
 
 // maybeAppendUserContent ensures the conversation ends with a user message.
-// Anthropic requires strictly alternating user/assistant turns.
+// Anthropic requires strictly alternating user/assistant turns. A trailing
+// model text turn is instead kept as an assistant prefill when prefilling is
+// enabled for the request.
 func (m *anthropicModel) maybeAppendUserContent(req *model.LLMRequest) {
+	prompts := m.continuationPrompts.withDefaults()
+	prefill := m.prefillEnabled(req)
 	if len(req.Contents) == 0 {
 		req.Contents = append(req.Contents,
-			genai.NewContentFromText("Handle the requests as specified in the System Instruction.", "user"))
+			genai.NewContentFromText(prompts.EmptyContents, "user"))
 		return
 	}
 
-	if last := req.Contents[len(req.Contents)-1]; last != nil && last.Role != "user" {
+	// Find the last content that becomes a message, with the roles the
+	// converter will give it: system-role contents join the system prompt,
+	// and tool results are user turns whatever their role.
+	lastIndex := -1
+	for i := len(req.Contents) - 1; i >= 0; i-- {
+		role, err := converters.MessageRole(req.Contents[i], m.strictRoles)
+		if err != nil {
+			// Conversion reports the error.
+			return
+		}
+		if role == anthropic.MessageParamRoleUser {
+			return
+		}
+		if role != "" {
+			lastIndex = i
+			break
+		}
+	}
+	if lastIndex < 0 {
+		// Only system-role or empty contents: no message would be sent.
 		req.Contents = append(req.Contents,
-			genai.NewContentFromText("Continue processing previous requests as instructed.", "user"))
+			genai.NewContentFromText(prompts.EmptyContents, "user"))
+		return
+	}
+	if prefill {
+		if content, ok := prefillContent(req.Contents[lastIndex]); ok {
+			// Copy the slice: it is shared with the caller's history.
+			contents := make([]*genai.Content, len(req.Contents))
+			copy(contents, req.Contents)
+			contents[lastIndex] = content
+			req.Contents = contents
+			return
+		}
+	}
+	req.Contents = append(req.Contents,
+		genai.NewContentFromText(prompts.AfterModelTurn, "user"))
+}
+
+// prefillEnabled reports whether a trailing model turn of req may be sent as
+// a prefill: AssistantPrefill, overridden by the request's PrefillLabel, on a
+// model that accepts prefills. The label is an adapter setting, not a
+// billing label, so it is removed from req; the caller's config is left
+// unchanged.
+func (m *anthropicModel) prefillEnabled(req *model.LLMRequest) bool {
+	enabled := m.assistantPrefill
+	if req.Config != nil {
+		if value, ok := req.Config.Labels[PrefillLabel]; ok {
+			if override, err := strconv.ParseBool(value); err == nil {
+				enabled = override
+			}
+			config := *req.Config
+			config.Labels = maps.Clone(req.Config.Labels)
+			delete(config.Labels, PrefillLabel)
+			if len(config.Labels) == 0 {
+				config.Labels = nil
+			}
+			req.Config = &config
+		}
+	}
+	return enabled && supportsPrefill(m.name)
+}
+
+// prefillRejectingFamilies are the model families that reject a conversation
+// ending with an assistant message: Claude Opus 4.6 and later.
+var prefillRejectingFamilies = []string{"claude-opus-4-6", "claude-opus-4-7", "claude-mythos"}
+
+// supportsPrefill reports whether model accepts a conversation ending with an
+// assistant message. It matches model families, so dated IDs
+// ("claude-opus-4-6-20260205"), Vertex IDs ("claude-opus-4-6@20260205") and
+// provider-qualified IDs are recognized as well as aliases.
+func supportsPrefill(model anthropic.Model) bool {
+	id := modelID(model)
+	for _, family := range prefillRejectingFamilies {
+		if id == family || strings.HasPrefix(id, family+"-") {
+			return false
+		}
+	}
+	return true
+}
+
+// modelID normalizes model for family matching: lower case, without a Vertex
+// "@version" suffix or a provider prefix such as "publishers/anthropic/models/"
+// or "us.anthropic.".
+func modelID(model anthropic.Model) string {
+	id := strings.ToLower(string(model))
+	id, _, _ = strings.Cut(id, "@")
+	if i := strings.LastIndexAny(id, "./"); i >= 0 {
+		id = id[i+1:]
+	}
+	return id
+}
+
+// prefillContent returns content ready to send as a prefill, or false when it
+// is not a model turn made only of text. The copy has trailing whitespace
+// trimmed, as Anthropic rejects a prefill ending in whitespace.
+func prefillContent(content *genai.Content) (*genai.Content, bool) {
+	role := strings.ToLower(content.Role)
+	if (role != "model" && role != "assistant") || len(content.Parts) == 0 {
+		return nil, false
+	}
+	for _, part := range content.Parts {
+		if part == nil || part.Text == "" || part.Thought || part.FunctionCall != nil || part.FunctionResponse != nil ||
+			part.InlineData != nil || part.FileData != nil || part.ExecutableCode != nil || part.CodeExecutionResult != nil {
+			return nil, false
+		}
+	}
+
+	lastPart := *content.Parts[len(content.Parts)-1]
+	lastPart.Text = strings.TrimRightFunc(lastPart.Text, unicode.IsSpace)
+	if lastPart.Text == "" {
+		return nil, false
 	}
+	copied := *content
+	copied.Parts = append(content.Parts[:len(content.Parts)-1:len(content.Parts)-1], &lastPart)
+	return &copied, true
 }
@@ -15,12 +15,18 @@
 package adkanthropic
 
 import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	"google.golang.org/adk/v2/model"
 	"google.golang.org/genai"
+
+	"github.com/Alcova-AI/adk-anthropic-go/v2/converters"
 )
 
 // testMaxTokens is an arbitrary non-zero max_tokens for constructing model
@@ -92,6 +98,60 @@ func TestNewModel_DefaultMaxTokensSupportsNonStreaming(t *testing.T) {
 	}
 }
 
+func TestGenerate_UpgradesToStreaming(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		maxTokens      int
+		forceStreaming bool
+		wantStream     bool
+	}{
+		{"default_stays_non_streaming", defaultMaxTokens, false, false},
+		{"sdk_would_refuse", 128000, false, true},
+		{"forced", defaultMaxTokens, true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var srv *httptest.Server
+			var requests func() []string
+			if tc.wantStream {
+				srv, requests = newRecordingServer(t, "text/event-stream", sseFromPayloads(t, resumedStream))
+			} else {
+				srv, requests = newRecordingServer(t, "application/json", resumedMessageJSON)
+			}
+			m, _ := newStreamTestModel(t, srv.URL)
+			m.defaultMaxTokens = tc.maxTokens
+			m.forceStreaming = tc.forceStreaming
+
+			var responses []*model.LLMResponse
+			for resp, err := range m.GenerateContent(t.Context(), &model.LLMRequest{}, false) {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				responses = append(responses, resp)
+			}
+
+			if len(responses) != 1 || responses[0].Partial {
+				t.Fatalf("got %d responses, want a single non-partial response", len(responses))
+			}
+			if got := responses[0].Content.Parts[0].Text; got != "Found it." {
+				t.Errorf("text = %q, want %q", got, "Found it.")
+			}
+			if got := responses[0].UsageMetadata.CandidatesTokenCount; got != 4 {
+				t.Errorf("output tokens = %d, want 4", got)
+			}
+
+			var sent struct {
+				Stream bool `json:"stream"`
+			}
+			if err := json.Unmarshal([]byte(requests()[0]), &sent); err != nil {
+				t.Fatalf("decode request: %v", err)
+			}
+			if sent.Stream != tc.wantStream {
+				t.Errorf("stream = %v, want %v", sent.Stream, tc.wantStream)
+			}
+		})
+	}
+}
+
 func TestNewModel_VertexAI_MissingConfig(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -141,7 +201,7 @@ func TestConvertRequest_VertexAI_SetsOutputConfig(t *testing.T) {
 		},
 	}
 
-	params, err := m.convertRequest(req)
+	params, _, err := m.convertRequest(req)
 	if err != nil {
 		t.Fatalf("convertRequest() error = %v", err)
 	}
@@ -188,7 +248,7 @@ func TestConvertRequest_OutputConfig_EnforcesAdditionalPropertiesFalse(t *testin
 		Config:   &genai.GenerateContentConfig{ResponseSchema: schema},
 	}
 
-	params, err := m.convertRequest(req)
+	params, _, err := m.convertRequest(req)
 	if err != nil {
 		t.Fatalf("convertRequest() error = %v", err)
 	}
@@ -247,7 +307,7 @@ func TestConvertRequest_VertexAI_TransformsUnsupportedSchemaConstraints(t *testi
 		Config:   &genai.GenerateContentConfig{ResponseSchema: schema},
 	}
 
-	params, err := m.convertRequest(req)
+	params, _, err := m.convertRequest(req)
 	if err != nil {
 		t.Fatalf("convertRequest() error = %v", err)
 	}
@@ -342,7 +402,7 @@ func TestConvertRequest_OutputConfig_EnforcesAdditionalPropertiesFalse_AnyOf(t *
 		Config:   &genai.GenerateContentConfig{ResponseSchema: schema},
 	}
 
-	params, err := m.convertRequest(req)
+	params, _, err := m.convertRequest(req)
 	if err != nil {
 		t.Fatalf("convertRequest() error = %v", err)
 	}
@@ -390,7 +450,7 @@ func TestConvertRequest_DirectAPI_SetsOutputConfig(t *testing.T) {
 		},
 	}
 
-	params, err := m.convertRequest(req)
+	params, _, err := m.convertRequest(req)
 	if err != nil {
 		t.Fatalf("convertRequest() error = %v", err)
 	}
@@ -442,7 +502,7 @@ func TestConvertRequest_DefaultsToAdaptiveOnCapableModel(t *testing.T) {
 				defaultMaxTokens: testMaxTokens,
 			}
 
-			params, err := m.convertRequest(tc.req)
+			params, _, err := m.convertRequest(tc.req)
 			if err != nil {
 				t.Fatalf("convertRequest() error = %v", err)
 			}
@@ -473,7 +533,7 @@ func TestConvertRequest_NilConfigLeavesThinkingOffOnNonAdaptive(t *testing.T) {
 		},
 	}
 
-	params, err := m.convertRequest(req)
+	params, _, err := m.convertRequest(req)
 	if err != nil {
 		t.Fatalf("convertRequest() error = %v", err)
 	}
@@ -597,7 +657,7 @@ func TestConvertRequest_ForcedToolUseDropsThinking(t *testing.T) {
 				},
 			}
 
-			params, err := m.convertRequest(req)
+			params, _, err := m.convertRequest(req)
 			if err != nil {
 				t.Fatalf("convertRequest() error = %v", err)
 			}
@@ -664,7 +724,7 @@ func TestConvertRequest_AutoToolUseKeepsThinking(t *testing.T) {
 				},
 			}
 
-			params, err := m.convertRequest(req)
+			params, _, err := m.convertRequest(req)
 			if err != nil {
 				t.Fatalf("convertRequest() error = %v", err)
 			}
@@ -676,4 +736,542 @@ func TestConvertRequest_AutoToolUseKeepsThinking(t *testing.T) {
 	}
 }
 
+func TestGenerate_InlinesFileData(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\nfake")
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(png)
+	}))
+	t.Cleanup(files.Close)
+
+	for _, tc := range []struct {
+		name       string
+		policies   map[string]URLSourcePolicy
+		wantSource string
+	}{
+		{"inline_by_default", nil, `"type":"base64"`},
+		{"pass_through_for_variant", map[string]URLSourcePolicy{VariantAnthropicAPI: URLSourcePassThrough}, `"type":"url"`},
+		{"other_variant_still_inlines", map[string]URLSourcePolicy{VariantVertexAI: URLSourcePassThrough}, `"type":"base64"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, requests := newRecordingServer(t, "application/json", resumedMessageJSON)
+			m, _ := newStreamTestModel(t, srv.URL)
+			m.fileDataInliner = newFileDataInliner(&FileDataInliningConfig{Policies: tc.policies}, m.variant, false)
+
+			filePart := genai.NewPartFromURI(files.URL+"/cat.png", "image/png")
+			req := &model.LLMRequest{Contents: []*genai.Content{
+				genai.NewContentFromParts([]*genai.Part{genai.NewPartFromText("What is this?"), filePart}, genai.RoleUser),
+			}}
+			for _, err := range m.GenerateContent(t.Context(), req, false) {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			body := requests()[0]
+			if !strings.Contains(body, tc.wantSource) {
+				t.Errorf("request body = %s, want an image source with %s", body, tc.wantSource)
+			}
+			if req.Contents[0].Parts[1] != filePart || filePart.FileData == nil || filePart.InlineData != nil {
+				t.Error("request contents were modified; want them copied")
+			}
+		})
+	}
+}
+
+func TestConvertRequest_TextExtractor(t *testing.T) {
+	const docx = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	m := &anthropicModel{
+		name:             "claude-haiku-4-5",
+		defaultMaxTokens: testMaxTokens,
+		textExtractor: converters.TextExtractorFunc(func(data []byte, mimeType string) (string, bool, error) {
+			return "quarterly figures", mimeType == docx, nil
+		}),
+	}
+	req := &model.LLMRequest{Contents: []*genai.Content{
+		genai.NewContentFromParts([]*genai.Part{genai.NewPartFromBytes([]byte("PK..."), docx)}, genai.RoleUser),
+	}}
+
+	params, _, err := m.convertRequest(req)
+	if err != nil {
+		t.Fatalf("convertRequest() error = %v", err)
+	}
+	doc := params.Messages[0].Content[0].OfDocument
+	if doc == nil || doc.Source.OfText == nil || doc.Source.OfText.Data != "quarterly figures" {
+		t.Errorf("block = %#v, want the extracted text as a plain-text document", params.Messages[0].Content[0])
+	}
+}
+
+func TestConvertRequest_DocumentCitations(t *testing.T) {
+	m := &anthropicModel{name: "claude-haiku-4-5", defaultMaxTokens: testMaxTokens, documentCitations: true}
+	req := &model.LLMRequest{Contents: []*genai.Content{
+		genai.NewContentFromParts([]*genai.Part{genai.NewPartFromBytes([]byte("%PDF"), "application/pdf")}, genai.RoleUser),
+	}}
+
+	params, _, err := m.convertRequest(req)
+	if err != nil {
+		t.Fatalf("convertRequest() error = %v", err)
+	}
+	if doc := params.Messages[0].Content[0].OfDocument; doc == nil || !doc.Citations.Enabled.Value {
+		t.Errorf("block = %#v, want a document with citations enabled", params.Messages[0].Content[0])
+	}
+}
+
+func TestConvertRequest_ToolErrorDetector(t *testing.T) {
+	m := &anthropicModel{
+		name:             "claude-haiku-4-5",
+		defaultMaxTokens: testMaxTokens,
+		toolErrorDetector: func(resp *genai.FunctionResponse) bool {
+			return resp.Response["status"] == "failed"
+		},
+	}
+	part := genai.NewPartFromFunctionResponse("deploy", map[string]any{"status": "failed"})
+	part.FunctionResponse.ID = "call_1"
+	req := &model.LLMRequest{Contents: []*genai.Content{
+		genai.NewContentFromParts([]*genai.Part{part}, genai.RoleUser),
+	}}
+
+	params, _, err := m.convertRequest(req)
+	if err != nil {
+		t.Fatalf("convertRequest() error = %v", err)
+	}
+	if result := params.Messages[0].Content[0].OfToolResult; result == nil || !result.IsError.Value {
+		t.Errorf("block = %#v, want a tool result with is_error set", params.Messages[0].Content[0])
+	}
+}
+
+func TestConvertRequest_HistorySanitizer(t *testing.T) {
+	var repairs []converters.HistoryRepair
+	m := &anthropicModel{
+		name:             "claude-haiku-4-5",
+		defaultMaxTokens: testMaxTokens,
+		historySanitizer: &converters.HistorySanitizer{
+			OnRepair: func(r converters.HistoryRepair) { repairs = append(repairs, r) },
+		},
+	}
+	req := &model.LLMRequest{Contents: []*genai.Content{
+		genai.NewContentFromText("look it up", genai.RoleUser),
+		genai.NewContentFromParts([]*genai.Part{{FunctionCall: &genai.FunctionCall{ID: "call_1", Name: "lookup"}}}, genai.RoleModel),
+	}}
+
+	params, _, err := m.convertRequest(req)
+	if err != nil {
+		t.Fatalf("convertRequest() error = %v", err)
+	}
+	if len(params.Messages) != 3 {
+		t.Fatalf("got %d messages, want the dangling call answered", len(params.Messages))
+	}
+	if result := params.Messages[2].Content[0].OfToolResult; result == nil || result.ToolUseID != "call_1" || !result.IsError.Value {
+		t.Errorf("block = %#v, want a synthesized error result for call_1", params.Messages[2].Content[0])
+	}
+	if len(repairs) != 1 || repairs[0].Action != converters.HistoryRepairSynthesizedResult {
+		t.Errorf("repairs = %+v, want one synthesized result", repairs)
+	}
+}
+
+func TestConvertRequest_HoistsSystemContents(t *testing.T) {
+	m := &anthropicModel{name: "claude-haiku-4-5", defaultMaxTokens: testMaxTokens}
+	req := &model.LLMRequest{
+		Contents: []*genai.Content{
+			genai.NewContentFromText("Answer in French.", "system"),
+			genai.NewContentFromText("hello", genai.RoleUser),
+		},
+		Config: &genai.GenerateContentConfig{
+			SystemInstruction: genai.NewContentFromText("You are a helpful assistant.", "system"),
+		},
+	}
+
+	params, _, err := m.convertRequest(req)
+	if err != nil {
+		t.Fatalf("convertRequest() error = %v", err)
+	}
+	var system []string
+	for _, block := range params.System {
+		system = append(system, block.Text)
+	}
+	if got, want := strings.Join(system, "|"), "You are a helpful assistant.|Answer in French."; got != want {
+		t.Errorf("system = %q, want %q", got, want)
+	}
+	if len(params.Messages) != 1 {
+		t.Errorf("got %d messages, want the system content hoisted out", len(params.Messages))
+	}
+
+	m.strictRoles = true
+	if _, _, err := m.convertRequest(req); err == nil {
+		t.Error("convertRequest() succeeded with strict roles, want an unsupported role error")
+	}
+}
+
+func TestMaybeAppendUserContent(t *testing.T) {
+	modelTurn := func(text string) *genai.Content { return genai.NewContentFromText(text, genai.RoleModel) }
+	withLabel := func(value string) *genai.GenerateContentConfig {
+		return &genai.GenerateContentConfig{Labels: map[string]string{PrefillLabel: value}}
+	}
+	call := genai.NewContentFromParts([]*genai.Part{{FunctionCall: &genai.FunctionCall{ID: "call_1", Name: "lookup"}}}, genai.RoleModel)
+	toolResult := func(role genai.Role) *genai.Content {
+		return &genai.Content{Role: string(role), Parts: []*genai.Part{{FunctionResponse: &genai.FunctionResponse{ID: "call_1", Name: "lookup"}}}}
+	}
+
+	tests := []struct {
+		name     string
+		model    *anthropicModel
+		contents []*genai.Content
+		config   *genai.GenerateContentConfig
+		want     []string // role:text of each content
+	}{
+		{
+			name:  "empty contents",
+			model: &anthropicModel{name: "claude-haiku-4-5"},
+			want:  []string{"user:" + DefaultEmptyContentsPrompt},
+		},
+		{
+			name:     "trailing model turn",
+			model:    &anthropicModel{name: "claude-haiku-4-5"},
+			contents: []*genai.Content{modelTurn("Sure")},
+			want:     []string{"model:Sure", "user:" + DefaultAfterModelTurnPrompt},
+		},
+		{
+			name: "custom prompts",
+			model: &anthropicModel{name: "claude-haiku-4-5", continuationPrompts: &ContinuationPromptsConfig{
+				EmptyContents:  "Begin.",
+				AfterModelTurn: "Go on.",
+			}},
+			contents: []*genai.Content{modelTurn("Sure")},
+			want:     []string{"model:Sure", "user:Go on."},
+		},
+		{
+			name:     "prefill",
+			model:    &anthropicModel{name: "claude-haiku-4-5", assistantPrefill: true},
+			contents: []*genai.Content{genai.NewContentFromText("List them", genai.RoleUser), modelTurn("{\n  ")},
+			want:     []string{"user:List them", "model:{"},
+		},
+		{
+			name:     "prefill enabled by label",
+			model:    &anthropicModel{name: "claude-haiku-4-5"},
+			contents: []*genai.Content{modelTurn("{")},
+			config:   withLabel("true"),
+			want:     []string{"model:{"},
+		},
+		{
+			name:     "prefill disabled by label",
+			model:    &anthropicModel{name: "claude-haiku-4-5", assistantPrefill: true},
+			contents: []*genai.Content{modelTurn("{")},
+			config:   withLabel("false"),
+			want:     []string{"model:{", "user:" + DefaultAfterModelTurnPrompt},
+		},
+		{
+			name:     "model without prefill support",
+			model:    &anthropicModel{name: anthropic.ModelClaudeOpus4_6, assistantPrefill: true},
+			contents: []*genai.Content{modelTurn("{")},
+			want:     []string{"model:{", "user:" + DefaultAfterModelTurnPrompt},
+		},
+		{
+			name:     "whitespace-only turn",
+			model:    &anthropicModel{name: "claude-haiku-4-5", assistantPrefill: true},
+			contents: []*genai.Content{modelTurn(" \n")},
+			want:     []string{"model: \n", "user:" + DefaultAfterModelTurnPrompt},
+		},
+		{
+			name:     "tool call turn",
+			model:    &anthropicModel{name: "claude-haiku-4-5", assistantPrefill: true},
+			contents: []*genai.Content{call},
+			want:     []string{"model:", "user:" + DefaultAfterModelTurnPrompt},
+		},
+		{
+			name:     "trailing tool role result",
+			model:    &anthropicModel{name: "claude-haiku-4-5"},
+			contents: []*genai.Content{call, toolResult("tool")},
+			want:     []string{"model:", "tool:"},
+		},
+		{
+			name:     "trailing function role result",
+			model:    &anthropicModel{name: "claude-haiku-4-5"},
+			contents: []*genai.Content{call, toolResult("function")},
+			want:     []string{"model:", "function:"},
+		},
+		{
+			name:     "trailing empty role result",
+			model:    &anthropicModel{name: "claude-haiku-4-5"},
+			contents: []*genai.Content{call, toolResult("")},
+			want:     []string{"model:", ":"},
+		},
+		{
+			name:     "trailing empty role text",
+			model:    &anthropicModel{name: "claude-haiku-4-5"},
+			contents: []*genai.Content{{Parts: []*genai.Part{{Text: "Hi"}}}},
+			want:     []string{":Hi"},
+		},
+		{
+			name:     "trailing capitalized user",
+			model:    &anthropicModel{name: "claude-haiku-4-5"},
+			contents: []*genai.Content{modelTurn("Sure"), genai.NewContentFromText("Thanks", "User")},
+			want:     []string{"model:Sure", "User:Thanks"},
+		},
+		{
+			name:     "trailing system content after user turn",
+			model:    &anthropicModel{name: "claude-haiku-4-5"},
+			contents: []*genai.Content{genai.NewContentFromText("Hi", genai.RoleUser), genai.NewContentFromText("Be brief", "system")},
+			want:     []string{"user:Hi", "system:Be brief"},
+		},
+		{
+			name:     "prefill before trailing system content",
+			model:    &anthropicModel{name: "claude-haiku-4-5", assistantPrefill: true},
+			contents: []*genai.Content{modelTurn("{ "), genai.NewContentFromText("Be brief", "system")},
+			want:     []string{"model:{", "system:Be brief"},
+		},
+		{
+			name:     "only system content",
+			model:    &anthropicModel{name: "claude-haiku-4-5"},
+			contents: []*genai.Content{genai.NewContentFromText("Be brief", "system")},
+			want:     []string{"system:Be brief", "user:" + DefaultEmptyContentsPrompt},
+		},
+		{
+			name:     "strict roles keep tool role as is",
+			model:    &anthropicModel{name: "claude-haiku-4-5", strictRoles: true},
+			contents: []*genai.Content{modelTurn("Sure"), genai.NewContentFromText("Hi", "tool")},
+			want:     []string{"model:Sure", "tool:Hi"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := make([]string, 0, len(tt.contents))
+			for _, c := range tt.contents {
+				original = append(original, c.Parts[0].Text)
+			}
+			req := &model.LLMRequest{Contents: tt.contents, Config: tt.config}
+			tt.model.maybeAppendUserContent(req)
+
+			var got []string
+			for _, c := range req.Contents {
+				got = append(got, c.Role+":"+c.Parts[0].Text)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("contents = %q, want %q", got, tt.want)
+			}
+			for i, c := range tt.contents {
+				if c.Parts[0].Text != original[i] {
+					t.Errorf("content %d was modified to %q", i, c.Parts[0].Text)
+				}
+			}
+		})
+	}
+}
+
+func TestSupportsPrefill(t *testing.T) {
+	for model, want := range map[anthropic.Model]bool{
+		anthropic.ModelClaudeSonnet4_6:                true,
+		anthropic.ModelClaudeOpus4_5_20251101:         true,
+		"claude-opus-4-20250514":                      true,
+		"claude-sonnet-4-6@20260217":                  true,
+		anthropic.ModelClaudeOpus4_6:                  false,
+		"claude-opus-4-6-20260205":                    false,
+		"claude-opus-4-6@20260205":                    false,
+		"publishers/anthropic/models/claude-opus-4-7": false,
+		"us.anthropic.claude-opus-4-6-v1:0":           false,
+		"Claude-Opus-4-7":                             false,
+		anthropic.ModelClaudeMythosPreview:            false,
+		"claude-mythos-preview@20260401":              false,
+	} {
+		if got := supportsPrefill(model); got != want {
+			t.Errorf("supportsPrefill(%q) = %v, want %v", model, got, want)
+		}
+	}
+}
+
+func TestMaybeAppendUserContent_ConsumesPrefillLabel(t *testing.T) {
+	config := &genai.GenerateContentConfig{Labels: map[string]string{PrefillLabel: "true", "team": "search"}}
+	req := &model.LLMRequest{
+		Contents: []*genai.Content{genai.NewContentFromText("{", genai.RoleModel)},
+		Config:   config,
+	}
+	(&anthropicModel{name: "claude-haiku-4-5"}).maybeAppendUserContent(req)
+
+	if len(req.Contents) != 1 {
+		t.Errorf("contents = %d, want the prefill alone", len(req.Contents))
+	}
+	if _, ok := req.Config.Labels[PrefillLabel]; ok || req.Config.Labels["team"] != "search" {
+		t.Errorf("request labels = %v, want only the billing label", req.Config.Labels)
+	}
+	if config.Labels[PrefillLabel] != "true" {
+		t.Errorf("caller's labels = %v, want them unchanged", config.Labels)
+	}
+
+	req = &model.LLMRequest{Config: &genai.GenerateContentConfig{Labels: map[string]string{PrefillLabel: "false"}}}
+	(&anthropicModel{name: "claude-haiku-4-5"}).maybeAppendUserContent(req)
+	if req.Config.Labels != nil {
+		t.Errorf("request labels = %v, want none", req.Config.Labels)
+	}
+}
+
+func TestConvertRequest_PrefillDisablesThinking(t *testing.T) {
+	m := &anthropicModel{name: anthropic.ModelClaudeSonnet4_6, defaultMaxTokens: testMaxTokens, assistantPrefill: true}
+	req := &model.LLMRequest{Contents: []*genai.Content{
+		genai.NewContentFromText("List them as JSON", genai.RoleUser),
+		genai.NewContentFromText("[", genai.RoleModel),
+	}}
+	m.maybeAppendUserContent(req)
+
+	params, _, err := m.convertRequest(req)
+	if err != nil {
+		t.Fatalf("convertRequest() error = %v", err)
+	}
+	if last := params.Messages[len(params.Messages)-1]; last.Role != anthropic.MessageParamRoleAssistant {
+		t.Fatalf("last message role = %q, want the assistant prefill", last.Role)
+	}
+	if params.Thinking.OfAdaptive != nil || params.Thinking.OfEnabled != nil || params.OutputConfig.Effort != "" {
+		t.Errorf("thinking = %#v, effort = %q, want both cleared for a prefill", params.Thinking, params.OutputConfig.Effort)
+	}
+}
+
+func TestConvertRequest_ThinkingBoundaryPrompt(t *testing.T) {
+	m := &anthropicModel{
+		name:                "claude-haiku-4-5",
+		defaultMaxTokens:    testMaxTokens,
+		continuationPrompts: &ContinuationPromptsConfig{ThinkingBoundary: "Carry on."},
+	}
+	thought := func(text string) *genai.Part {
+		return &genai.Part{Text: text, Thought: true, ThoughtSignature: []byte("sig")}
+	}
+	req := &model.LLMRequest{Contents: []*genai.Content{
+		genai.NewContentFromText("hi", genai.RoleUser),
+		genai.NewContentFromParts([]*genai.Part{thought("one")}, genai.RoleModel),
+		genai.NewContentFromParts([]*genai.Part{thought("two")}, genai.RoleModel),
+		genai.NewContentFromText("next", genai.RoleUser),
+	}}
+
+	params, _, err := m.convertRequest(req)
+	if err != nil {
+		t.Fatalf("convertRequest() error = %v", err)
+	}
+	if got := params.Messages[2].Content[0].OfText; got == nil || got.Text != "Carry on." {
+		t.Errorf("boundary message = %#v, want the configured prompt", params.Messages[2])
+	}
+}
+
+func TestGenerate_TrimsHistory(t *testing.T) {
+	srv, requests := newRecordingServer(t, "application/json", resumedMessageJSON)
+	m, _ := newStreamTestModel(t, srv.URL)
+	m.historyTrimmer = converters.LastTurnsTrimmer{Turns: 1}
+
+	req := &model.LLMRequest{Contents: []*genai.Content{
+		genai.NewContentFromText("first question", genai.RoleUser),
+		genai.NewContentFromText("first answer", genai.RoleModel),
+		genai.NewContentFromText("second question", genai.RoleUser),
+		genai.NewContentFromText("second answer", genai.RoleModel),
+	}}
+	var final *model.LLMResponse
+	for resp, err := range m.GenerateContent(t.Context(), req, false) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		final = resp
+	}
+
+	body := requests()[0]
+	if strings.Contains(body, "first question") || !strings.Contains(body, "second answer") {
+		t.Errorf("request body = %s, want only the last turn", body)
+	}
+	if !strings.Contains(body, DefaultAfterModelTurnPrompt) {
+		t.Errorf("request body = %s, want the continuation prompt after the kept model turn", body)
+	}
+	trimmed, _ := final.CustomMetadata[converters.TrimmedHistoryMetadataKey].(*converters.TrimmedHistory)
+	if trimmed == nil || trimmed.OriginalContents != 4 || len(trimmed.DroppedContents) != 2 ||
+		trimmed.DroppedContents[0] != 0 || trimmed.DroppedContents[1] != 1 {
+		t.Errorf("trimmed history metadata = %+v, want contents 0 and 1 of 4 dropped", trimmed)
+	}
+}
+
+func TestGenerateContent_ContextManagement(t *testing.T) {
+	const applied = `"context_management":{"applied_edits":[{"type":"clear_tool_uses_20250919","cleared_input_tokens":5000,"cleared_tool_uses":3}]}`
+	for _, stream := range []bool{false, true} {
+		t.Run(map[bool]string{false: "unary", true: "stream"}[stream], func(t *testing.T) {
+			body, contentType := strings.TrimSuffix(resumedMessageJSON, "}")+","+applied+"}", "application/json"
+			if stream {
+				events := append([]string(nil), resumedStream...)
+				events[4] = `{"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":4},` + applied + `}`
+				body, contentType = sseFromPayloads(t, events), "text/event-stream"
+			}
+			var betas []string
+			var request string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				betas = r.Header.Values("anthropic-beta")
+				raw, _ := io.ReadAll(r.Body)
+				request = string(raw)
+				w.Header().Set("Content-Type", contentType)
+				_, _ = io.WriteString(w, body)
+			}))
+			t.Cleanup(srv.Close)
+
+			m, _ := newStreamTestModel(t, srv.URL)
+			m.contextManagement = &ContextManagementConfig{
+				ClearToolUses: &ClearToolUsesConfig{TriggerToolUses: 10, KeepToolUses: 2, ExcludeTools: []string{"memory"}},
+				ClearThinking: &ClearThinkingConfig{KeepTurns: 1},
+			}
+
+			req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("hi", genai.RoleUser)}}
+			var final *model.LLMResponse
+			for resp, err := range m.GenerateContent(t.Context(), req, stream) {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !resp.Partial {
+					final = resp
+				}
+			}
+
+			if got := strings.Join(betas, ","); got != string(anthropic.AnthropicBetaContextManagement2025_06_27) {
+				t.Errorf("anthropic-beta = %q, want the context management beta", got)
+			}
+			for _, want := range []string{`"clear_tool_uses_20250919"`, `"trigger":{"value":10,"type":"tool_uses"}`, `"exclude_tools":["memory"]`} {
+				if !strings.Contains(request, want) {
+					t.Errorf("request body = %s, want it to contain %s", request, want)
+				}
+			}
+			if strings.Contains(request, "clear_thinking") {
+				t.Errorf("request body = %s, want no thinking edit without extended thinking", request)
+			}
+			edits, _ := final.CustomMetadata[converters.ContextManagementMetadataKey].([]converters.AppliedContextEdit)
+			if len(edits) != 1 || edits[0].Type != "clear_tool_uses_20250919" || edits[0].ClearedToolUses != 3 || edits[0].ClearedInputTokens != 5000 {
+				t.Errorf("context management metadata = %+v, want the applied edit", edits)
+			}
+		})
+	}
+}
+
+func TestNewModel_ContextManagementTriggers(t *testing.T) {
+	_, err := NewModel(t.Context(), "claude-haiku-4-5", &Config{
+		APIKey:  "test-key",
+		Variant: VariantAnthropicAPI,
+		ContextManagement: &ContextManagementConfig{
+			ClearToolUses: &ClearToolUsesConfig{TriggerInputTokens: 30000, TriggerToolUses: 10},
+		},
+	})
+	if err == nil {
+		t.Fatal("NewModel succeeded, want an error for two triggers")
+	}
+}
+
+func TestContextManagementEdits(t *testing.T) {
+	cfg := &ContextManagementConfig{
+		ClearToolUses: &ClearToolUsesConfig{ClearToolInputs: true},
+		ClearThinking: &ClearThinkingConfig{},
+	}
+	edits := cfg.edits(true)
+	if len(edits) != 2 || edits[0].OfClearThinking20251015 == nil || edits[1].OfClearToolUses20250919 == nil {
+		t.Fatalf("edits = %+v, want clear_thinking then clear_tool_uses", edits)
+	}
+	raw, err := json.Marshal(anthropic.BetaContextManagementConfigParam{Edits: edits})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	want := `{"edits":[{"type":"clear_thinking_20251015"},{"clear_tool_inputs":true,"type":"clear_tool_uses_20250919"}]}`
+	if string(raw) != want {
+		t.Errorf("edits JSON = %s, want %s", raw, want)
+	}
+	if edits := (*ContextManagementConfig)(nil).edits(true); edits != nil {
+		t.Errorf("nil config edits = %+v, want none", edits)
+	}
+}
+
 func ptrInt32(v int32) *int32 { return &v }
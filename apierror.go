@@ -0,0 +1,193 @@
+// Copyright 2026 Alcova AI
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adkanthropic
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// Sentinels classifying Anthropic API failures. Every error returned by
+// GenerateContent that originates from an API error response — an HTTP error
+// status or an error event delivered mid-stream — matches exactly one of them
+// with errors.Is, and errors.As with *APIError exposes the details. Failures
+// that fit none of these (e.g. api_error, billing_error) are returned
+// unclassified as before.
+var (
+	ErrRateLimited     = errors.New("anthropic: rate limited")
+	ErrOverloaded      = errors.New("anthropic: overloaded")
+	ErrInvalidRequest  = errors.New("anthropic: invalid request")
+	ErrAuthentication  = errors.New("anthropic: authentication failed")
+	ErrPermission      = errors.New("anthropic: permission denied")
+	ErrNotFound        = errors.New("anthropic: not found")
+	ErrRequestTooLarge = errors.New("anthropic: request too large")
+	ErrContextTooLong  = errors.New("anthropic: context too long")
+)
+
+// errorTypeRequestTooLarge is the API error type for HTTP 413 responses; the
+// SDK has no constant for it.
+const errorTypeRequestTooLarge anthropic.ErrorType = "request_too_large"
+
+// APIError is a classified Anthropic API failure. It unwraps to both its
+// Kind sentinel and the original error, so errors.Is(err, ErrRateLimited)
+// and errors.As(err, **anthropic.Error) both keep working. Its message is
+// the original error's, so logs and error grouping are unchanged.
+type APIError struct {
+	// Kind is one of the Err* sentinels above.
+	Kind error
+
+	// StatusCode is the HTTP status of the response. It is 200 for errors
+	// delivered as a stream event after the request was accepted.
+	StatusCode int
+
+	// RequestID is the request-id response header, for support tickets.
+	// Empty if the response didn't carry one.
+	RequestID string
+
+	// RetryAfter is the delay the API asked for before retrying, from the
+	// retry-after-ms or retry-after header. Zero when absent; only rate
+	// limits and overloads carry it in practice.
+	RetryAfter time.Duration
+
+	// Err is the original error, typically an *anthropic.Error.
+	Err error
+}
+
+func (e *APIError) Error() string { return e.Err.Error() }
+
+func (e *APIError) Unwrap() []error { return []error{e.Kind, e.Err} }
+
+// classifyAPIError wraps an Anthropic API error in an *APIError. Errors that
+// aren't API errors, are already classified, or fit no sentinel are returned
+// unchanged.
+func classifyAPIError(err error) error {
+	var classified *APIError
+	if errors.As(err, &classified) {
+		return err
+	}
+	var apierr *anthropic.Error
+	if !errors.As(err, &apierr) {
+		return err
+	}
+	kind := apiErrorKind(apierr)
+	if kind == nil {
+		return err
+	}
+	out := &APIError{
+		Kind:       kind,
+		StatusCode: apierr.StatusCode,
+		RequestID:  apierr.RequestID,
+		Err:        err,
+	}
+	if apierr.Response != nil {
+		out.RetryAfter = parseRetryAfter(apierr.Response.Header)
+	}
+	return out
+}
+
+// apiErrorKind maps an API error to its sentinel. The error type from the
+// body wins; the HTTP status is the fallback for bodies without one (e.g. a
+// proxy's error page). Mid-stream errors arrive with status 200, so they are
+// only ever classified by type.
+func apiErrorKind(apierr *anthropic.Error) error {
+	switch apierr.Type() {
+	case anthropic.ErrorTypeRateLimitError:
+		return ErrRateLimited
+	case anthropic.ErrorTypeOverloadedError:
+		return ErrOverloaded
+	case errorTypeRequestTooLarge:
+		return ErrRequestTooLarge
+	case anthropic.ErrorTypeInvalidRequestError:
+		if isContextTooLong(apiErrorMessage(apierr)) {
+			return ErrContextTooLong
+		}
+		return ErrInvalidRequest
+	case anthropic.ErrorTypeAuthenticationError:
+		return ErrAuthentication
+	case anthropic.ErrorTypePermissionError:
+		return ErrPermission
+	case anthropic.ErrorTypeNotFoundError:
+		return ErrNotFound
+	case "":
+	default:
+		return nil
+	}
+
+	switch apierr.StatusCode {
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case 529:
+		return ErrOverloaded
+	case http.StatusRequestEntityTooLarge:
+		return ErrRequestTooLarge
+	case http.StatusBadRequest:
+		return ErrInvalidRequest
+	case http.StatusUnauthorized:
+		return ErrAuthentication
+	case http.StatusForbidden:
+		return ErrPermission
+	case http.StatusNotFound:
+		return ErrNotFound
+	}
+	return nil
+}
+
+// isContextTooLong reports whether an invalid_request_error message says the
+// prompt (or prompt plus max_tokens) exceeds the model's context window. The
+// API has no dedicated error type for this, so match its wording.
+func isContextTooLong(message string) bool {
+	message = strings.ToLower(message)
+	return strings.Contains(message, "prompt is too long") ||
+		strings.Contains(message, "exceed context limit") ||
+		strings.Contains(message, "context window")
+}
+
+// apiErrorMessage extracts error.message from the API error body.
+func apiErrorMessage(apierr *anthropic.Error) string {
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal([]byte(apierr.RawJSON()), &body) != nil {
+		return ""
+	}
+	return body.Error.Message
+}
+
+// parseRetryAfter reads the retry delay from the retry-after-ms header
+// (milliseconds, Anthropic-specific) or the standard retry-after header
+// (seconds or an HTTP date).
+func parseRetryAfter(h http.Header) time.Duration {
+	if ms, err := strconv.ParseFloat(h.Get("retry-after-ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	value := h.Get("retry-after")
+	if secs, err := strconv.ParseFloat(value, 64); err == nil && secs > 0 {
+		return time.Duration(secs * float64(time.Second))
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,141 @@
+// Copyright 2026 Alcova AI
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adkanthropic
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"google.golang.org/adk/v2/model"
+)
+
+// newErrorServer answers every request with the given HTTP status and API
+// error body. retry-after-ms keeps the SDK's own retries of 429/529 fast.
+func newErrorServer(t *testing.T, status int, errorType, message string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("request-id", "req_test")
+		w.Header().Set("retry-after-ms", "5")
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, `{"type":"error","error":{"type":"`+errorType+`","message":"`+message+`"}}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestAPIErrorTaxonomy(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		status    int
+		errorType string
+		message   string
+		want      error
+	}{
+		{"rate_limited", http.StatusTooManyRequests, "rate_limit_error", "slow down", ErrRateLimited},
+		{"overloaded", 529, "overloaded_error", "Overloaded", ErrOverloaded},
+		{"invalid_request", http.StatusBadRequest, "invalid_request_error", "messages: field required", ErrInvalidRequest},
+		{"context_too_long", http.StatusBadRequest, "invalid_request_error", "prompt is too long: 215000 tokens > 200000 maximum", ErrContextTooLong},
+		{"authentication", http.StatusUnauthorized, "authentication_error", "invalid x-api-key", ErrAuthentication},
+		{"permission", http.StatusForbidden, "permission_error", "no access", ErrPermission},
+		{"not_found", http.StatusNotFound, "not_found_error", "model: not found", ErrNotFound},
+		{"request_too_large", http.StatusRequestEntityTooLarge, "request_too_large", "Request exceeds the maximum size", ErrRequestTooLarge},
+		{"status_fallback", http.StatusTooManyRequests, "", "", ErrRateLimited},
+	} {
+		for _, stream := range []bool{false, true} {
+			name := tc.name + "/generate"
+			if stream {
+				name = tc.name + "/stream"
+			}
+			t.Run(name, func(t *testing.T) {
+				srv := newErrorServer(t, tc.status, tc.errorType, tc.message)
+				m, _ := newStreamTestModel(t, srv.URL)
+
+				var err error
+				for _, e := range m.GenerateContent(t.Context(), &model.LLMRequest{}, stream) {
+					if e != nil {
+						err = e
+					}
+				}
+
+				if !errors.Is(err, tc.want) {
+					t.Fatalf("err = %v, want errors.Is %v", err, tc.want)
+				}
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) {
+					t.Fatalf("err = %T, want *APIError", err)
+				}
+				if apiErr.RequestID != "req_test" || apiErr.StatusCode != tc.status {
+					t.Errorf("APIError = %+v, want request id req_test and status %d", apiErr, tc.status)
+				}
+				if apiErr.RetryAfter != 5*time.Millisecond {
+					t.Errorf("RetryAfter = %v, want 5ms", apiErr.RetryAfter)
+				}
+				var sdkErr *anthropic.Error
+				if !errors.As(err, &sdkErr) {
+					t.Error("original *anthropic.Error must stay reachable via errors.As")
+				}
+			})
+		}
+	}
+}
+
+func TestAPIErrorTaxonomy_MidStream(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		errorType string
+		want      error
+	}{
+		{"rate_limited", "rate_limit_error", ErrRateLimited},
+		{"overloaded_after_retries", "overloaded_error", ErrOverloaded},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			body := "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"" + tc.errorType + "\",\"message\":\"mid-stream\"}}\n\n"
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.Header().Set("request-id", "req_stream")
+				w.WriteHeader(http.StatusOK)
+				_, _ = io.WriteString(w, body)
+			}))
+			t.Cleanup(srv.Close)
+			m, _ := newStreamTestModel(t, srv.URL)
+
+			pairs := collect(t.Context(), m)
+			err := pairs[len(pairs)-1].err
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("err = %v, want errors.Is %v", err, tc.want)
+			}
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.RequestID != "req_stream" || apiErr.StatusCode != http.StatusOK {
+				t.Errorf("APIError = %+v, want request id req_stream and status 200", apiErr)
+			}
+		})
+	}
+}
+
+func TestClassifyAPIError_Unclassified(t *testing.T) {
+	plain := errors.New("boom")
+	if got := classifyAPIError(plain); got != plain {
+		t.Errorf("classifyAPIError(non-API error) = %v, want it unchanged", got)
+	}
+	if got := classifyAPIError(nil); got != nil {
+		t.Errorf("classifyAPIError(nil) = %v, want nil", got)
+	}
+}
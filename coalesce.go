@@ -0,0 +1,120 @@
+// Copyright 2026 Alcova AI
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adkanthropic
+
+import (
+	"strings"
+	"time"
+
+	"google.golang.org/adk/v2/model"
+
+	"github.com/Alcova-AI/adk-anthropic-go/v2/converters"
+)
+
+// deltaCoalescer turns streamed text and thinking deltas into partial
+// responses. With a nil config every delta passes straight through; otherwise
+// deltas of the same kind are buffered until a StreamCoalescingConfig
+// threshold is crossed or the caller flushes at a block boundary.
+type deltaCoalescer struct {
+	cfg *StreamCoalescingConfig
+	now func() time.Time
+
+	buf     strings.Builder
+	thought bool
+	index   int64
+	started time.Time
+}
+
+func newDeltaCoalescer(cfg *StreamCoalescingConfig) *deltaCoalescer {
+	return &deltaCoalescer{cfg: cfg, now: time.Now}
+}
+
+// add buffers a delta of the content block at index, emitting whatever the
+// thresholds release. It returns false once emit reports that the consumer
+// stopped.
+func (c *deltaCoalescer) add(text string, thought bool, index int64, emit func(*model.LLMResponse) bool) bool {
+	if c.cfg == nil {
+		return emit(partialDelta(text, thought, index))
+	}
+
+	// Never mix thinking and text, or two blocks, in one partial: flush
+	// the buffer first so their order is preserved.
+	if c.buf.Len() > 0 && (thought != c.thought || index != c.index) {
+		if !c.flush(emit) {
+			return false
+		}
+	}
+	if c.buf.Len() == 0 {
+		c.thought = thought
+		c.index = index
+		c.started = c.now()
+	}
+	c.buf.WriteString(text)
+
+	if (c.cfg.MaxBytes > 0 && c.buf.Len() >= c.cfg.MaxBytes) ||
+		(c.cfg.MaxInterval > 0 && c.now().Sub(c.started) >= c.cfg.MaxInterval) {
+		return c.flush(emit)
+	}
+	return true
+}
+
+// next advances the stream with advance and reports whether it has another
+// event. While deltas are buffered and MaxInterval is set, advance runs on
+// another goroutine so that the buffer is flushed when the interval elapses
+// even if the stream has gone quiet; emit is only ever called from the
+// caller's goroutine. If the consumer stops during such a flush, next calls
+// abort to end the stream, waits for advance to return, and reports ok as
+// false.
+func (c *deltaCoalescer) next(advance func() bool, emit func(*model.LLMResponse) bool, abort func()) (more, ok bool) {
+	if c.cfg == nil || c.cfg.MaxInterval <= 0 || c.buf.Len() == 0 {
+		return advance(), true
+	}
+
+	result := make(chan bool, 1)
+	go func() { result <- advance() }()
+	timer := time.NewTimer(max(c.cfg.MaxInterval-c.now().Sub(c.started), 0))
+	defer timer.Stop()
+	select {
+	case more := <-result:
+		return more, true
+	case <-timer.C:
+	}
+	if !c.flush(emit) {
+		abort()
+		<-result
+		return false, false
+	}
+	return <-result, true
+}
+
+// flush emits any buffered delta. It returns false once emit reports that the
+// consumer stopped.
+func (c *deltaCoalescer) flush(emit func(*model.LLMResponse) bool) bool {
+	if c.buf.Len() == 0 {
+		return true
+	}
+	resp := partialDelta(c.buf.String(), c.thought, c.index)
+	c.buf.Reset()
+	return emit(resp)
+}
+
+func partialDelta(text string, thought bool, index int64) *model.LLMResponse {
+	resp := converters.StreamDeltaToPartialResponse(text)
+	if thought {
+		resp = converters.StreamThinkingDeltaToPartialResponse(text)
+	}
+	converters.SetBlockIndex(resp, index)
+	return resp
+}
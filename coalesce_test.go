@@ -0,0 +1,179 @@
+// Copyright 2026 Alcova AI
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adkanthropic
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/adk/v2/model"
+	"google.golang.org/genai"
+)
+
+// multiDeltaStream streams a thinking block and a text block, each as several
+// deltas.
+var multiDeltaStream = []string{
+	`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-haiku-4-5","content":[],"stop_reason":null,"usage":{"input_tokens":3,"output_tokens":0}}}`,
+	`{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":"","signature":""}}`,
+	`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"weighing "}}`,
+	`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"options"}}`,
+	`{"type":"content_block_delta","index":0,"delta":{"type":"signature_delta","signature":"c2ln"}}`,
+	`{"type":"content_block_stop","index":0}`,
+	`{"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}`,
+	`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"Hello"}}`,
+	`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":", "}}`,
+	`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"world"}}`,
+	`{"type":"content_block_stop","index":1}`,
+	`{"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":9}}`,
+	`{"type":"message_stop"}`,
+}
+
+type emittedDelta struct {
+	Text    string
+	Thought bool
+}
+
+func TestGenerateStream_CoalescesDeltas(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cfg  *StreamCoalescingConfig
+		want []emittedDelta
+	}{
+		{
+			name: "disabled",
+			cfg:  nil,
+			want: []emittedDelta{
+				{"weighing ", true}, {"options", true},
+				{"Hello", false}, {", ", false}, {"world", false},
+			},
+		},
+		{
+			name: "block_boundaries_only",
+			cfg:  &StreamCoalescingConfig{},
+			want: []emittedDelta{{"weighing options", true}, {"Hello, world", false}},
+		},
+		{
+			name: "size_threshold",
+			cfg:  &StreamCoalescingConfig{MaxBytes: 6},
+			want: []emittedDelta{
+				{"weighing ", true}, {"options", true},
+				{"Hello, ", false}, {"world", false},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, _ := newSSEServer(t, sseFromPayloads(t, multiDeltaStream))
+			m, _ := newStreamTestModel(t, srv.URL)
+			m.streamCoalescing = tc.cfg
+
+			pairs := contentPairs(collect(t.Context(), m))
+
+			var got []emittedDelta
+			for _, p := range pairs[:len(pairs)-1] {
+				if p.err != nil || !p.resp.Partial {
+					t.Fatalf("pair = %+v, want a partial delta", p)
+				}
+				part := p.resp.Content.Parts[0]
+				got = append(got, emittedDelta{part.Text, part.Thought})
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("partials mismatch (-want +got):\n%s", diff)
+			}
+
+			final := pairs[len(pairs)-1]
+			if final.err != nil || !final.resp.TurnComplete {
+				t.Fatalf("final = %+v, want complete response", final)
+			}
+			parts := final.resp.Content.Parts
+			if len(parts) != 2 || parts[0].Text != "weighing options" || parts[1].Text != "Hello, world" {
+				t.Errorf("final parts = %+v, want the full thinking and text blocks", parts)
+			}
+		})
+	}
+}
+
+func TestDeltaCoalescer_IntervalAndKindSwitch(t *testing.T) {
+	clock := time.Unix(0, 0)
+	c := newDeltaCoalescer(&StreamCoalescingConfig{MaxInterval: 100 * time.Millisecond})
+	c.now = func() time.Time { return clock }
+
+	var got []emittedDelta
+	emit := func(resp *model.LLMResponse) bool {
+		part := resp.Content.Parts[0]
+		got = append(got, emittedDelta{part.Text, part.Thought})
+		return true
+	}
+
+	c.add("a", true, 0, emit)
+	clock = clock.Add(50 * time.Millisecond)
+	c.add("b", true, 0, emit)
+	// A kind switch flushes the buffered thinking before the text.
+	c.add("c", false, 1, emit)
+	clock = clock.Add(100 * time.Millisecond)
+	// The interval has elapsed since "c" was buffered.
+	c.add("d", false, 1, emit)
+	c.add("e", false, 1, emit)
+	c.flush(emit)
+
+	want := []emittedDelta{{"ab", true}, {"cd", false}, {"e", false}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("emitted mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestGenerateStream_CoalescerFlushesWhileStreamIsQuiet(t *testing.T) {
+	// The server sends one delta, then goes quiet until the test has seen
+	// it flushed; without a timed flush the test would wait for the server's
+	// own timeout and get both deltas in one partial.
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, messagePrefixSSE+
+			"event: content_block_delta\n"+
+			"data: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hel\"}}\n\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-time.After(5 * time.Second):
+		}
+		_, _ = io.WriteString(w, strings.Replace(strings.TrimPrefix(successSSE, messagePrefixSSE), `"Hello"`, `"lo"`, 1))
+	}))
+	t.Cleanup(srv.Close)
+	m, _ := newStreamTestModel(t, srv.URL)
+	m.streamCoalescing = &StreamCoalescingConfig{MaxInterval: 20 * time.Millisecond}
+
+	req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("hi", genai.RoleUser)}}
+	var texts []string
+	for resp, err := range m.GenerateContent(t.Context(), req, true) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.Partial && resp.Content != nil {
+			texts = append(texts, resp.Content.Parts[0].Text)
+			if len(texts) == 1 {
+				close(release)
+			}
+		}
+	}
+	if diff := cmp.Diff([]string{"Hel", "lo"}, texts); diff != "" {
+		t.Errorf("partials mismatch (-want +got):\n%s", diff)
+	}
+}
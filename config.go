@@ -14,7 +14,13 @@
 
 package adkanthropic
 
-import "github.com/anthropics/anthropic-sdk-go"
+import (
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+
+	"github.com/Alcova-AI/adk-anthropic-go/v2/converters"
+)
 
 // CacheBreakpoint configures a single cache control breakpoint.
 type CacheBreakpoint struct {
@@ -43,6 +49,139 @@ type PromptCachingConfig struct {
 	ConversationHistory *CacheBreakpoint
 }
 
+// StreamCoalescingConfig enables coalescing of streamed text and thinking
+// deltas. Instead of one partial LLMResponse per delta, deltas are buffered
+// and emitted together when a threshold is reached or the content block ends.
+// A switch between thinking and text always flushes first, so their relative
+// order is preserved. The final response is unaffected.
+type StreamCoalescingConfig struct {
+	// MaxBytes flushes once at least this many bytes are buffered.
+	// Zero disables the size threshold.
+	MaxBytes int
+
+	// MaxInterval flushes once the oldest buffered delta is at least this
+	// old, including while the stream is quiet between events, so buffered
+	// text never waits longer than this for the next event. Zero disables
+	// the interval threshold.
+	MaxInterval time.Duration
+}
+
+// MaxTokensContinuationConfig enables automatic continuation of plain text
+// responses that stop at max_tokens. The model re-requests with the partial
+// answer as an assistant prefill and stitches the pieces into one response:
+// streamed deltas continue seamlessly, and the final response carries the
+// concatenated text and summed usage.
+//
+// Only responses made of text (and thinking that precedes it) are continued;
+// a response cut off inside a tool call or a thinking block is returned as
+// before. Continuation requests are sent with extended thinking disabled,
+// because Anthropic does not accept an assistant prefill alongside it.
+// Models that reject an assistant prefill altogether (Claude Opus 4.6 and
+// later) are not continued. A continuation request that fails leaves the
+// truncated response as it was, unless the stream had already carried part
+// of the continuation, in which case the error is reported.
+type MaxTokensContinuationConfig struct {
+	// TotalOutputTokens caps the output tokens of the original response
+	// and all continuations combined. Each continuation's max_tokens is
+	// reduced to what remains, and continuation stops once it is spent.
+	// It should exceed the request's max_tokens to have any effect.
+	TotalOutputTokens int
+}
+
+// URLSourcePolicy decides how image and PDF FileData parts reach Anthropic.
+type URLSourcePolicy int
+
+const (
+	// URLSourceInline downloads the file and sends it as a base64 source.
+	URLSourceInline URLSourcePolicy = iota
+
+	// URLSourcePassThrough sends the FileData URI as a URL source, for
+	// Anthropic to fetch itself.
+	URLSourcePassThrough
+)
+
+// FileDataInliningConfig enables downloading image and PDF FileData URIs and
+// sending their bytes inline, for backends that reject URL sources and for
+// URIs Anthropic cannot reach (gs:// objects, internal hosts). FileData parts
+// nested in FunctionResponse.Parts are inlined too.
+type FileDataInliningConfig struct {
+	// Fetcher downloads each URI. Nil means a fetcher for http and https
+	// URIs; use converters.SchemeBlobFetcher to add gs:// through
+	// converters.GCSBlobFetcher.
+	Fetcher converters.BlobFetcher
+
+	// MaxBytes caps the size of each download; a larger file fails the
+	// request. Zero means converters.DefaultMaxBlobBytes.
+	MaxBytes int64
+
+	// CacheBytes bounds the in-memory cache of downloads that the model's
+	// requests share, so each file of a conversation is downloaded once
+	// rather than on every turn. Zero means
+	// converters.DefaultBlobCacheBytes; a negative value disables the
+	// cache. A failed download fails the request, even for a file deep in
+	// the history; it does not fall back to a URL source.
+	CacheBytes int64
+
+	// Policies chooses per backend variant (VariantAnthropicAPI,
+	// VariantVertexAI) between inlining and URL pass-through. Variants not
+	// listed inline.
+	Policies map[string]URLSourcePolicy
+}
+
+// PrefillLabel is the GenerateContentConfig.Labels key that overrides
+// Config.AssistantPrefill for a single request, with the value "true" or
+// "false". Labels can be set from a before-model callback, which makes them
+// the one per-request channel ADK offers. The adapter consumes this label:
+// it is removed from the request's config before anything is sent, so it
+// never reaches Vertex AI billing labels or other consumers of the request.
+const PrefillLabel = "anthropic-prefill"
+
+// Default texts of the user messages added to keep a conversation valid for
+// Anthropic. The text inserted at thinking boundaries is
+// converters.DefaultContinuationPrompt.
+const (
+	DefaultEmptyContentsPrompt  = "Handle the requests as specified in the System Instruction."
+	DefaultAfterModelTurnPrompt = "Continue processing previous requests as instructed."
+)
+
+// ContinuationPromptsConfig sets the texts of the user messages added to keep
+// a conversation valid for Anthropic, which requires it to start and end with
+// a user turn (unless the last model turn is a prefill). Empty fields keep
+// the defaults.
+type ContinuationPromptsConfig struct {
+	// EmptyContents is sent when a request has no contents. Default:
+	// DefaultEmptyContentsPrompt.
+	EmptyContents string
+
+	// AfterModelTurn is appended when the history ends with a model turn
+	// that is not sent as a prefill. Default: DefaultAfterModelTurnPrompt.
+	AfterModelTurn string
+
+	// ThinkingBoundary is inserted between consecutive model turns that
+	// cannot be merged without modifying a thinking block. Default:
+	// converters.DefaultContinuationPrompt.
+	ThinkingBoundary string
+}
+
+// withDefaults returns the prompts with empty texts replaced by the defaults.
+// c may be nil.
+func (c *ContinuationPromptsConfig) withDefaults() ContinuationPromptsConfig {
+	var prompts ContinuationPromptsConfig
+	if c != nil {
+		prompts = *c
+	}
+	if prompts.EmptyContents == "" {
+		prompts.EmptyContents = DefaultEmptyContentsPrompt
+	}
+	if prompts.AfterModelTurn == "" {
+		prompts.AfterModelTurn = DefaultAfterModelTurnPrompt
+	}
+	if prompts.ThinkingBoundary == "" {
+		prompts.ThinkingBoundary = converters.DefaultContinuationPrompt
+	}
+	return prompts
+}
+
 // Config holds configuration for creating an Anthropic Claude model.
 type Config struct {
 	// APIKey is the Anthropic API key for direct API access.
@@ -69,9 +208,10 @@ type Config struct {
 	// DefaultMaxTokens is the default maximum number of tokens to generate.
 	// Anthropic requires max_tokens to be explicitly set for all requests.
 	// If not provided, it defaults to 16384 so both streaming and non-streaming
-	// requests remain valid. Callers that intentionally stream large outputs
-	// should set a larger deployment-level value or use the per-request
-	// GenerateContentConfig.MaxOutputTokens override.
+	// requests remain valid as plain HTTP calls. Callers that want larger
+	// outputs should set a larger deployment-level value or use the
+	// per-request GenerateContentConfig.MaxOutputTokens override; non-streaming
+	// calls above the SDK's limit are then sent as streaming requests.
 	DefaultMaxTokens int
 
 	BaseURL string
@@ -79,4 +219,146 @@ type Config struct {
 	// PromptCaching configures optional prompt caching breakpoints.
 	// When nil (the default), no cache control is applied.
 	PromptCaching *PromptCachingConfig
+
+	// StreamFirstEventTimeout bounds how long a streaming request may wait
+	// for its first event (normally message_start), including the wait for
+	// response headers. A stall here is retried like a mid-stream overload.
+	// Zero (the default) waits for as long as the request context allows.
+	StreamFirstEventTimeout time.Duration
+
+	// StreamIdleTimeout bounds the gap between consecutive stream events
+	// after the first. Keep-alive pings don't count as events, so a stream
+	// that only pings stalls once this elapses. Time spent waiting for the
+	// consumer to accept a yielded response is not counted. A stall before
+	// any content was yielded is retried; after content it surfaces as a
+	// *StreamStalledError. Zero (the default) disables the watchdog.
+	StreamIdleTimeout time.Duration
+
+	// StreamCoalescing buffers streamed text and thinking deltas into fewer,
+	// larger partial responses. When nil (the default), every delta is
+	// yielded as its own partial response.
+	StreamCoalescing *StreamCoalescingConfig
+
+	// RefusalAsError returns a response that Anthropic stopped with
+	// stop_reason "refusal" as a *RefusalError instead. When false (the
+	// default), it is returned as a normal response with FinishReasonSafety
+	// and the refusal details in CustomMetadata.
+	RefusalAsError bool
+
+	// MaxPauseTurnContinuations lets the model continue a turn that
+	// Anthropic paused (stop_reason "pause_turn", typically during a long
+	// server-tool loop such as web search) by re-submitting the paused
+	// content, up to this many times per GenerateContent call. The result
+	// is one response with the combined content and usage; when streaming,
+	// the continuations appear as a single uninterrupted stream. A
+	// continuation request that fails before streaming anything leaves the
+	// paused response as it was. Zero (the default) disables continuation
+	// and returns the paused response with FinishReasonOther.
+	MaxPauseTurnContinuations int
+
+	// ForceStreaming sends every non-streaming GenerateContent call as a
+	// streaming request and returns the accumulated message as a single
+	// non-partial response. Without it, only requests the SDK would reject
+	// as too long for a non-streaming call (large max_tokens) are upgraded
+	// this way; everything else is sent as a plain request.
+	ForceStreaming bool
+
+	// MaxTokensContinuation, when set, continues plain text responses that
+	// stop at max_tokens. See MaxTokensContinuationConfig.
+	MaxTokensContinuation *MaxTokensContinuationConfig
+
+	// FileDataInlining, when set, downloads image and PDF FileData URIs and
+	// sends them inline instead of as URL sources. When nil (the default),
+	// FileData URIs are passed to Anthropic as URL sources.
+	FileDataInlining *FileDataInliningConfig
+
+	// ImageNormalization, when set, downscales inline images to its size
+	// limits, converts formats Anthropic does not accept (BMP, TIFF) and
+	// recompresses images over its byte limit, instead of letting them
+	// fail server-side. The final response lists the images it changed
+	// under CustomMetadata["anthropic.normalized_images"]. Unless it has a
+	// Cache, the model gives it one of DefaultImageCacheBytes so each image
+	// is re-encoded once. It also covers images FileDataInlining
+	// downloads, which then accepts every image type for it to convert.
+	// When nil (the default), images are sent as provided.
+	ImageNormalization *converters.ImageNormalization
+
+	// TextExtractor converts inline documents that are neither images, PDF
+	// nor text, such as office formats, to plain-text document blocks.
+	// Text-like data (text/*, JSON, XML, YAML, ...) is sent as plain-text
+	// documents without it. When nil (the default), other formats fail
+	// conversion as unsupported.
+	TextExtractor converters.TextExtractor
+
+	// DocumentCitations enables citations on every document sent to the
+	// model (PDF, text and extracted documents, including those in tool
+	// results). A single part can opt in or out with
+	// converters.CitationsEnabledMetadataKey in its PartMetadata. Cited
+	// ranges are mapped into CitationMetadata, and the cited text and
+	// document index of each citation into
+	// CustomMetadata["anthropic.citations"].
+	DocumentCitations bool
+
+	// ToolErrorDetector decides which function responses record a failed
+	// tool call, sent to the model as a tool_result with is_error set.
+	// When nil (the default), converters.DefaultToolErrorDetector
+	// recognizes ADK's {"error": "<message>"} responses.
+	ToolErrorDetector converters.ToolErrorDetector
+
+	// ToolResultUnwrapping, when set, sends textual tool results as raw
+	// text blocks instead of JSON: single-string responses, the string
+	// values of the configured keys, and text FunctionResponse.Parts.
+	// When nil (the default), every response is sent as JSON and text
+	// parts as plain-text documents.
+	ToolResultUnwrapping *converters.ToolResultUnwrapping
+
+	// HistorySanitizer, when set, repairs the tool calls and tool results
+	// left unpaired by a cancelled tool run or a crashed session, which
+	// Anthropic rejects: missing results are synthesized as errors, results
+	// answering no tool call are dropped, and results are moved ahead of
+	// other content of their turn. Its OnRepair callback reports each
+	// change. When nil (the default), the history is sent as is.
+	HistorySanitizer *converters.HistorySanitizer
+
+	// StrictRoles fails requests whose contents have a role other than
+	// user, model or assistant. By default, histories imported from other
+	// frameworks are accepted: system-role contents are appended to the
+	// system prompt after the SystemInstruction, in order, tool and
+	// function roles are treated as user tool results, and an empty role
+	// defaults to user.
+	StrictRoles bool
+
+	// AssistantPrefill sends a history that ends with a model turn made of
+	// text as an assistant prefill, which the response continues, instead
+	// of appending a user continuation prompt. Use it to steer the output
+	// format, e.g. with a trailing "{". The response holds only the
+	// continuation, not the prefill. Trailing whitespace is trimmed from
+	// the prefill, as Anthropic rejects it, and extended thinking is
+	// disabled for the request, as Anthropic does not accept it alongside
+	// a prefill. Models that reject prefills (Claude Opus 4.6 and later,
+	// matched by family, including dated and Vertex IDs) get the
+	// continuation prompt. A request overrides this setting with
+	// the PrefillLabel label.
+	AssistantPrefill bool
+
+	// ContinuationPrompts overrides the texts of the user messages added
+	// to keep a conversation valid. When nil (the default), the built-in
+	// texts are used.
+	ContinuationPrompts *ContinuationPromptsConfig
+
+	// HistoryTrimmer, when set, shortens each request's contents before
+	// conversion to keep long sessions within the context window: e.g.
+	// converters.LastTurnsTrimmer, converters.TokenBudgetTrimmer, or
+	// converters.KeepFirstTurnTrimmer around either. The built-in trimmers
+	// drop whole turns, keeping tool calls paired with their results and
+	// thinking blocks intact. The final response describes what was
+	// dropped under CustomMetadata["anthropic.trimmed_history"]. When nil
+	// (the default), the whole history is sent.
+	HistoryTrimmer converters.HistoryTrimmer
+
+	// ContextManagement, when set, enables Anthropic's server-side context
+	// management (beta), which clears old tool results and thinking once
+	// the context passes a threshold. See ContextManagementConfig. When
+	// nil (the default), the full context is sent to the model.
+	ContextManagement *ContextManagementConfig
 }
@@ -0,0 +1,161 @@
+// Copyright 2026 Alcova AI
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adkanthropic
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/anthropics/anthropic-sdk-go/packages/param"
+
+	"github.com/Alcova-AI/adk-anthropic-go/v2/converters"
+)
+
+// ContextManagementConfig enables Anthropic's server-side context management
+// (beta), which clears old tool results and thinking from the context sent
+// to the model once it grows past a threshold. The conversation history
+// itself is left as is; Anthropic applies the edits on each request. The
+// beta header is added automatically, and the edits Anthropic applied are
+// reported in the final response under
+// CustomMetadata["anthropic.context_management"].
+type ContextManagementConfig struct {
+	// ClearToolUses, when set, clears the oldest tool results.
+	ClearToolUses *ClearToolUsesConfig
+
+	// ClearThinking, when set, clears thinking blocks from older assistant
+	// turns. It applies only to requests with extended thinking enabled.
+	ClearThinking *ClearThinkingConfig
+}
+
+// ClearToolUsesConfig configures the clearing of old tool results. Zero
+// fields use Anthropic's defaults.
+type ClearToolUsesConfig struct {
+	// TriggerInputTokens starts clearing once the input exceeds this many
+	// tokens.
+	TriggerInputTokens int
+
+	// TriggerToolUses starts clearing once the context holds more than
+	// this many tool uses. Set at most one trigger; with neither,
+	// Anthropic's default input token threshold applies.
+	TriggerToolUses int
+
+	// KeepToolUses is the number of most recent tool uses whose results
+	// are kept.
+	KeepToolUses int
+
+	// ClearAtLeastTokens skips clearing unless at least this many input
+	// tokens can be cleared, so the prompt cache is not invalidated for a
+	// small saving.
+	ClearAtLeastTokens int
+
+	// ExcludeTools names tools whose uses are never cleared.
+	ExcludeTools []string
+
+	// ClearToolInputs also clears the inputs of cleared tool calls, not
+	// only their results.
+	ClearToolInputs bool
+}
+
+// ClearThinkingConfig configures the clearing of old thinking blocks.
+type ClearThinkingConfig struct {
+	// KeepTurns is the number of most recent assistant turns whose
+	// thinking is kept. Zero uses Anthropic's default.
+	KeepTurns int
+}
+
+// validate reports a configuration Anthropic would reject. c may be nil.
+func (c *ContextManagementConfig) validate() error {
+	if c == nil || c.ClearToolUses == nil {
+		return nil
+	}
+	if c.ClearToolUses.TriggerInputTokens > 0 && c.ClearToolUses.TriggerToolUses > 0 {
+		return fmt.Errorf("ContextManagement.ClearToolUses: set at most one of TriggerInputTokens and TriggerToolUses")
+	}
+	return nil
+}
+
+// edits returns the context management edits for a request, or nil when
+// none apply. thinking reports whether the request has extended thinking
+// enabled, which clearing thinking requires. c may be nil.
+func (c *ContextManagementConfig) edits(thinking bool) []anthropic.BetaContextManagementConfigEditUnionParam {
+	if c == nil {
+		return nil
+	}
+
+	var edits []anthropic.BetaContextManagementConfigEditUnionParam
+	// Anthropic requires the thinking edit to come first.
+	if c.ClearThinking != nil && thinking {
+		edit := &anthropic.BetaClearThinking20251015EditParam{}
+		if c.ClearThinking.KeepTurns > 0 {
+			edit.Keep.OfThinkingTurns = &anthropic.BetaThinkingTurnsParam{Value: int64(c.ClearThinking.KeepTurns)}
+		}
+		edits = append(edits, anthropic.BetaContextManagementConfigEditUnionParam{OfClearThinking20251015: edit})
+	}
+
+	if tools := c.ClearToolUses; tools != nil {
+		edit := &anthropic.BetaClearToolUses20250919EditParam{ExcludeTools: tools.ExcludeTools}
+		switch {
+		case tools.TriggerInputTokens > 0:
+			edit.Trigger.OfInputTokens = &anthropic.BetaInputTokensTriggerParam{Value: int64(tools.TriggerInputTokens)}
+		case tools.TriggerToolUses > 0:
+			edit.Trigger.OfToolUses = &anthropic.BetaToolUsesTriggerParam{Value: int64(tools.TriggerToolUses)}
+		}
+		if tools.KeepToolUses > 0 {
+			edit.Keep = anthropic.BetaToolUsesKeepParam{Value: int64(tools.KeepToolUses)}
+		}
+		if tools.ClearAtLeastTokens > 0 {
+			edit.ClearAtLeast = anthropic.BetaInputTokensClearAtLeastParam{Value: int64(tools.ClearAtLeastTokens)}
+		}
+		if tools.ClearToolInputs {
+			edit.ClearToolInputs.OfBool = param.NewOpt(true)
+		}
+		edits = append(edits, anthropic.BetaContextManagementConfigEditUnionParam{OfClearToolUses20250919: edit})
+	}
+	return edits
+}
+
+// applyContextManagement sets the configured context management edits on
+// params to match its thinking configuration. It is called again whenever
+// that configuration changes, as clearing thinking requires thinking to be
+// enabled.
+func (m *anthropicModel) applyContextManagement(params *anthropic.MessageNewParams) {
+	if m.contextManagement == nil {
+		return
+	}
+	thinking := params.Thinking.OfEnabled != nil || params.Thinking.OfAdaptive != nil
+	extra := maps.Clone(params.ExtraFields())
+	if extra == nil {
+		extra = map[string]any{}
+	}
+	delete(extra, converters.ContextManagementField)
+	if edits := m.contextManagement.edits(thinking); len(edits) > 0 {
+		extra[converters.ContextManagementField] = anthropic.BetaContextManagementConfigParam{Edits: edits}
+	}
+	params.SetExtraFields(extra)
+}
+
+// requestOptions returns the options for sending params: the response is
+// stored in httpResp, and the context management beta is enabled when params
+// use it.
+func requestOptions(params anthropic.MessageNewParams, httpResp **http.Response) []option.RequestOption {
+	opts := []option.RequestOption{option.WithResponseInto(httpResp)}
+	if _, ok := params.ExtraFields()[converters.ContextManagementField]; ok {
+		opts = append(opts, option.WithHeaderAdd("anthropic-beta", string(anthropic.AnthropicBetaContextManagement2025_06_27)))
+	}
+	return opts
+}
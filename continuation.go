@@ -0,0 +1,379 @@
+// Copyright 2026 Alcova AI
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adkanthropic
+
+import (
+	"encoding/json"
+	"maps"
+	"strings"
+	"unicode"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/packages/respjson"
+	"google.golang.org/adk/v2/model"
+
+	"github.com/Alcova-AI/adk-anthropic-go/v2/converters"
+)
+
+// continuationCounter tracks the automatic continuations made within one
+// GenerateContent call.
+type continuationCounter struct {
+	pauses int
+}
+
+// continuation is a follow-up request that resumes a response Anthropic
+// stopped early, and how to fold its result into the response so far.
+type continuation struct {
+	params anthropic.MessageNewParams
+
+	// prefilled marks a max_tokens continuation: the request ends with the
+	// partial text as an assistant prefill, so the reply's text continues
+	// the last text block rather than starting a new one.
+	prefilled bool
+
+	// trimLeading is set when trailing whitespace was cut from the prefill
+	// (Anthropic rejects it). The consumer already has that whitespace, so
+	// the reply's leading whitespace is dropped to avoid doubling it.
+	trimLeading bool
+}
+
+// nextContinuation returns the request that continues msg, or false when msg
+// is final. params is the original request; history is taken from it.
+func (m *anthropicModel) nextContinuation(params anthropic.MessageNewParams, msg *anthropic.Message, counter *continuationCounter) (continuation, bool) {
+	switch msg.StopReason {
+	case anthropic.StopReasonPauseTurn:
+		if counter.pauses >= m.maxPauseTurnContinuations {
+			return continuation{}, false
+		}
+		counter.pauses++
+		params.Messages = withPausedTurn(params.Messages, msg)
+		return continuation{params: params}, true
+
+	case anthropic.StopReasonMaxTokens:
+		// The continuation ends with an assistant prefill, which some
+		// models reject outright.
+		if m.maxTokensContinuation == nil || !isPlainTextMessage(msg) || !supportsPrefill(m.name) {
+			return continuation{}, false
+		}
+		remaining := int64(m.maxTokensContinuation.TotalOutputTokens) - msg.Usage.OutputTokens
+		if remaining <= 0 {
+			return continuation{}, false
+		}
+		text := messageText(msg)
+		prefill := strings.TrimRightFunc(text, unicode.IsSpace)
+		if prefill == "" {
+			return continuation{}, false
+		}
+		params.MaxTokens = min(params.MaxTokens, remaining)
+		// Anthropic rejects an assistant prefill when extended thinking is
+		// enabled; effort is meaningless without it.
+		params.Thinking = anthropic.ThinkingConfigParamUnion{}
+		params.OutputConfig.Effort = ""
+		m.applyContextManagement(&params)
+		params.Messages = append(params.Messages[:len(params.Messages):len(params.Messages)],
+			anthropic.NewAssistantMessage(anthropic.NewTextBlock(prefill)))
+		return continuation{
+			params:      params,
+			prefilled:   true,
+			trimLeading: len(prefill) < len(text),
+		}, true
+	}
+	return continuation{}, false
+}
+
+// merge folds the continuation's reply into the response so far.
+func (c continuation) merge(prev, next *anthropic.Message) *anthropic.Message {
+	merged := mergeContinuation(prev, next)
+	if !c.prefilled {
+		return merged
+	}
+
+	// Stitch the first text block of the reply onto the last text block of
+	// prev, so the final response reads as one uninterrupted answer.
+	// Blocks with citations are left apart: the stitched block could not
+	// keep them.
+	boundary := len(prev.Content)
+	if boundary == 0 || boundary == len(merged.Content) ||
+		merged.Content[boundary-1].Type != "text" || merged.Content[boundary].Type != "text" ||
+		len(merged.Content[boundary-1].Citations) > 0 || len(merged.Content[boundary].Citations) > 0 {
+		return merged
+	}
+	tail := merged.Content[boundary].Text
+	if c.trimLeading {
+		tail = strings.TrimLeftFunc(tail, unicode.IsSpace)
+	}
+	stitched := textContentBlock(merged.Content[boundary-1].Text + tail)
+	merged.Content = append(append(merged.Content[:boundary-1:boundary-1], stitched), merged.Content[boundary+1:]...)
+	return merged
+}
+
+// wrapYield adapts the consumer's yield for the continuation's stream,
+// dropping leading whitespace from the first text delta when trimLeading is
+// set so the streamed text matches the stitched final response.
+func (c continuation) wrapYield(yield func(*model.LLMResponse, error) bool) func(*model.LLMResponse, error) bool {
+	if !c.trimLeading {
+		return yield
+	}
+	trimming := true
+	return func(resp *model.LLMResponse, err error) bool {
+		if trimming && err == nil && resp != nil && resp.Content != nil && len(resp.Content.Parts) == 1 {
+			part := resp.Content.Parts[0]
+			if !part.Thought && part.Text != "" {
+				part.Text = strings.TrimLeftFunc(part.Text, unicode.IsSpace)
+				if part.Text == "" {
+					return true
+				}
+				trimming = false
+			}
+		}
+		return yield(resp, err)
+	}
+}
+
+// streamSegment passes the partials of one segment of a continued stream to
+// the consumer, so that the segments read as one stream. The partial
+// reporting the segment's stop reason is held back until it is known whether
+// another segment follows; only the last segment's keeps its stop reason and
+// finish reason. Continuation segments drop their message_start partial,
+// report usage summed over all segments so far, and number their blocks after
+// the blocks already streamed, so indices never repeat. The final response
+// of a max_tokens continuation joins the continued text into one block,
+// which the streamed indices do not reflect.
+type streamSegment struct {
+	yield func(*model.LLMResponse, error) bool
+
+	// prev is the merged message of the segments before this one, nil for
+	// the first segment.
+	prev *anthropic.Message
+
+	// held is the partial carrying the segment's stop reason.
+	held *model.LLMResponse
+
+	// pending is the previous segment's held partial. It is passed on
+	// without its stop reason once this segment streams something, or as
+	// is by abandon.
+	pending *model.LLMResponse
+
+	// streamed is set once the segment has yielded to the consumer.
+	streamed bool
+
+	// err is the error a continuation segment failed with before it
+	// streamed anything.
+	err error
+}
+
+func newStreamSegment(yield func(*model.LLMResponse, error) bool) *streamSegment {
+	return &streamSegment{yield: yield}
+}
+
+// next returns the segment continuing s, streamed with yield. prev is the
+// merged message of s and the segments before it.
+func (s *streamSegment) next(yield func(*model.LLMResponse, error) bool, prev *anthropic.Message) *streamSegment {
+	pending := s.held
+	s.held = nil
+	return &streamSegment{yield: yield, prev: prev, pending: pending}
+}
+
+// wrap is the yield the segment is streamed with.
+func (s *streamSegment) wrap(resp *model.LLMResponse, err error) bool {
+	if err != nil && s.prev != nil && !s.streamed {
+		// The consumer has seen nothing of this segment, so the stream
+		// can still end with the response so far; see abandon.
+		s.err = err
+		return false
+	}
+	if err != nil || resp == nil || !resp.Partial {
+		// The stream ends here, so the held partial was the last one.
+		if !s.finish() {
+			return false
+		}
+		return s.emit(resp, err)
+	}
+
+	// Message partials (message_start and message_delta) are the only
+	// content-free partials that carry usage.
+	if resp.Content == nil && resp.UsageMetadata != nil {
+		if s.prev != nil {
+			prevUsage := converters.UsageToMetadata(s.prev.Usage)
+			resp.UsageMetadata.PromptTokenCount += prevUsage.PromptTokenCount
+			resp.UsageMetadata.CandidatesTokenCount += prevUsage.CandidatesTokenCount
+			resp.UsageMetadata.TotalTokenCount += prevUsage.TotalTokenCount
+			resp.UsageMetadata.CachedContentTokenCount += prevUsage.CachedContentTokenCount
+		}
+		if _, ok := resp.CustomMetadata[converters.StopReasonMetadataKey]; ok {
+			s.held = resp
+			return true
+		}
+		if s.prev != nil {
+			// The consumer already has the first segment's message_start.
+			return true
+		}
+	}
+
+	if s.prev != nil {
+		if index, ok := resp.CustomMetadata[converters.BlockIndexMetadataKey].(int64); ok {
+			converters.SetBlockIndex(resp, index+int64(len(s.prev.Content)))
+		}
+	}
+	return s.emit(resp, nil)
+}
+
+// emit yields resp, preceded by the pending partial stripped of its stop
+// reason and finish reason, since this segment continues it. It reports
+// whether the consumer wants more.
+func (s *streamSegment) emit(resp *model.LLMResponse, err error) bool {
+	s.streamed = true
+	if pending := s.pending; pending != nil {
+		s.pending = nil
+		pending.FinishReason = ""
+		for _, key := range []string{
+			converters.StopReasonMetadataKey,
+			converters.StopSequenceMetadataKey,
+			converters.RefusalCategoryMetadataKey,
+			converters.RefusalExplanationMetadataKey,
+		} {
+			delete(pending.CustomMetadata, key)
+		}
+		if !s.yield(pending, nil) {
+			return false
+		}
+	}
+	return s.yield(resp, err)
+}
+
+// finish yields the held stop partial as the last partial of the stream. It
+// reports whether the consumer wants more.
+func (s *streamSegment) finish() bool {
+	resp := s.held
+	s.held = nil
+	if resp == nil {
+		return true
+	}
+	return s.emit(resp, nil)
+}
+
+// abandon ends the stream with the segments before s, after s failed without
+// streaming anything: the pending partial is yielded with its stop reason.
+// It reports whether the consumer wants more.
+func (s *streamSegment) abandon() bool {
+	resp := s.pending
+	s.pending = nil
+	if resp == nil {
+		return true
+	}
+	return s.yield(resp, nil)
+}
+
+// isPlainTextMessage reports whether msg is a text answer that max_tokens
+// continuation may resume: only text and thinking blocks, ending in text.
+func isPlainTextMessage(msg *anthropic.Message) bool {
+	if len(msg.Content) == 0 || msg.Content[len(msg.Content)-1].Type != "text" {
+		return false
+	}
+	for _, block := range msg.Content {
+		switch block.Type {
+		case "text", "thinking", "redacted_thinking":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// messageText concatenates the text blocks of msg.
+func messageText(msg *anthropic.Message) string {
+	var b strings.Builder
+	for _, block := range msg.Content {
+		if block.Type == "text" {
+			b.WriteString(block.Text)
+		}
+	}
+	return b.String()
+}
+
+// textContentBlock builds a response text block. It goes through JSON so the
+// union's backing raw JSON, which AsAny reads, matches its fields.
+func textContentBlock(text string) anthropic.ContentBlockUnion {
+	raw, _ := json.Marshal(map[string]string{"type": "text", "text": text})
+	var block anthropic.ContentBlockUnion
+	_ = block.UnmarshalJSON(raw)
+	return block
+}
+
+// withPausedTurn returns history followed by the paused assistant turn, the
+// request shape Anthropic expects for resuming a pause_turn response. The
+// paused content is always sent as one trailing assistant message, so repeated
+// pauses extend that message rather than adding consecutive assistant turns.
+func withPausedTurn(history []anthropic.MessageParam, paused *anthropic.Message) []anthropic.MessageParam {
+	messages := make([]anthropic.MessageParam, 0, len(history)+1)
+	messages = append(messages, history...)
+	return append(messages, paused.ToParam())
+}
+
+// mergeContinuation combines a message with the message that continued it.
+// Content is concatenated and usage is summed; identity and stop fields come
+// from next, which describes where the combined turn ended.
+func mergeContinuation(prev, next *anthropic.Message) *anthropic.Message {
+	merged := *next
+	merged.Content = make([]anthropic.ContentBlockUnion, 0, len(prev.Content)+len(next.Content))
+	merged.Content = append(merged.Content, prev.Content...)
+	merged.Content = append(merged.Content, next.Content...)
+
+	merged.Usage.InputTokens += prev.Usage.InputTokens
+	merged.Usage.OutputTokens += prev.Usage.OutputTokens
+	merged.Usage.CacheCreationInputTokens += prev.Usage.CacheCreationInputTokens
+	merged.Usage.CacheReadInputTokens += prev.Usage.CacheReadInputTokens
+	merged.Usage.ServerToolUse.WebSearchRequests += prev.Usage.ServerToolUse.WebSearchRequests
+	merged.Usage.ServerToolUse.WebFetchRequests += prev.Usage.ServerToolUse.WebFetchRequests
+	mergeContextManagement(&merged, prev, next)
+	return &merged
+}
+
+// mergeContextManagement sets merged's context_management to the edits
+// applied to prev's requests followed by those applied to next's, so the
+// final response reports every request of the turn.
+func mergeContextManagement(merged *anthropic.Message, prev, next *anthropic.Message) {
+	prevEdits := appliedEditsJSON(prev)
+	if len(prevEdits) == 0 {
+		return
+	}
+	edits := append(prevEdits[:len(prevEdits):len(prevEdits)], appliedEditsJSON(next)...)
+	raw, err := json.Marshal(map[string]any{"applied_edits": edits})
+	if err != nil {
+		return
+	}
+	extra := maps.Clone(next.JSON.ExtraFields)
+	if extra == nil {
+		extra = make(map[string]respjson.Field)
+	}
+	extra[converters.ContextManagementField] = respjson.NewField(string(raw))
+	merged.JSON.ExtraFields = extra
+}
+
+// appliedEditsJSON returns the raw applied_edits reported in msg's
+// context_management field.
+func appliedEditsJSON(msg *anthropic.Message) []json.RawMessage {
+	raw := msg.JSON.ExtraFields[converters.ContextManagementField].Raw()
+	if raw == "" || raw == respjson.Null {
+		return nil
+	}
+	var cm struct {
+		AppliedEdits []json.RawMessage `json:"applied_edits"`
+	}
+	if err := json.Unmarshal([]byte(raw), &cm); err != nil {
+		return nil
+	}
+	return cm.AppliedEdits
+}
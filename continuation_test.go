@@ -0,0 +1,521 @@
+// Copyright 2026 Alcova AI
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adkanthropic
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/adk/v2/model"
+	"google.golang.org/genai"
+
+	"github.com/Alcova-AI/adk-anthropic-go/v2/converters"
+)
+
+// newRecordingServer answers the i-th request with bodies[i] (repeating the
+// last) and records every request body it receives.
+func newRecordingServer(t *testing.T, contentType string, bodies ...string) (*httptest.Server, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		i := len(requests)
+		requests = append(requests, string(body))
+		mu.Unlock()
+		if i >= len(bodies) {
+			i = len(bodies) - 1
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, bodies[i])
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), requests...)
+	}
+}
+
+const (
+	pausedMessageJSON  = `{"id":"msg_1","type":"message","role":"assistant","model":"claude-haiku-4-5","content":[{"type":"text","text":"Searching. "}],"stop_reason":"pause_turn","usage":{"input_tokens":10,"output_tokens":3,"server_tool_use":{"web_search_requests":1}}}`
+	resumedMessageJSON = `{"id":"msg_2","type":"message","role":"assistant","model":"claude-haiku-4-5","content":[{"type":"text","text":"Found it."}],"stop_reason":"end_turn","usage":{"input_tokens":14,"output_tokens":4,"server_tool_use":{"web_search_requests":2}}}`
+)
+
+var pausedStream = []string{
+	`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-haiku-4-5","content":[],"stop_reason":null,"usage":{"input_tokens":10,"output_tokens":0}}}`,
+	`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+	`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Searching. "}}`,
+	`{"type":"content_block_stop","index":0}`,
+	`{"type":"message_delta","delta":{"stop_reason":"pause_turn","stop_sequence":null},"usage":{"output_tokens":3}}`,
+	`{"type":"message_stop"}`,
+}
+
+var resumedStream = []string{
+	`{"type":"message_start","message":{"id":"msg_2","type":"message","role":"assistant","model":"claude-haiku-4-5","content":[],"stop_reason":null,"usage":{"input_tokens":14,"output_tokens":0}}}`,
+	`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+	`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Found it."}}`,
+	`{"type":"content_block_stop","index":0}`,
+	`{"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":4}}`,
+	`{"type":"message_stop"}`,
+}
+
+func TestPauseTurnContinuation(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		stream        bool
+		continuations int
+		wantRequests  int
+		wantTexts     []string
+		wantFinish    genai.FinishReason
+		wantOutput    int32
+	}{
+		{"generate_disabled", false, 0, 1, []string{"Searching. "}, genai.FinishReasonOther, 3},
+		{"generate_continued", false, 2, 2, []string{"Searching. ", "Found it."}, genai.FinishReasonStop, 7},
+		{"stream_disabled", true, 0, 1, []string{"Searching. "}, genai.FinishReasonOther, 3},
+		{"stream_continued", true, 2, 2, []string{"Searching. ", "Found it."}, genai.FinishReasonStop, 7},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var srv *httptest.Server
+			var requests func() []string
+			if tc.stream {
+				srv, requests = newRecordingServer(t, "text/event-stream",
+					sseFromPayloads(t, pausedStream), sseFromPayloads(t, resumedStream))
+			} else {
+				srv, requests = newRecordingServer(t, "application/json",
+					pausedMessageJSON, resumedMessageJSON)
+			}
+			m, _ := newStreamTestModel(t, srv.URL)
+			m.maxPauseTurnContinuations = tc.continuations
+
+			req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("find it", genai.RoleUser)}}
+			var deltas []string
+			var finals []*model.LLMResponse
+			for resp, err := range m.GenerateContent(t.Context(), req, tc.stream) {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				switch {
+				case !resp.Partial:
+					finals = append(finals, resp)
+				case resp.Content != nil:
+					deltas = append(deltas, resp.Content.Parts[0].Text)
+				}
+			}
+
+			if got := len(requests()); got != tc.wantRequests {
+				t.Fatalf("requests = %d, want %d", got, tc.wantRequests)
+			}
+			if len(finals) != 1 {
+				t.Fatalf("got %d final responses, want exactly 1", len(finals))
+			}
+			final := finals[0]
+			if tc.stream && !slices.Equal(deltas, tc.wantTexts) {
+				t.Errorf("deltas = %q, want %q", deltas, tc.wantTexts)
+			}
+			var texts []string
+			for _, p := range final.Content.Parts {
+				texts = append(texts, p.Text)
+			}
+			if !slices.Equal(texts, tc.wantTexts) {
+				t.Errorf("final parts = %q, want %q", texts, tc.wantTexts)
+			}
+			if final.FinishReason != tc.wantFinish {
+				t.Errorf("FinishReason = %q, want %q", final.FinishReason, tc.wantFinish)
+			}
+			if got := final.UsageMetadata.CandidatesTokenCount; got != tc.wantOutput {
+				t.Errorf("output tokens = %d, want %d", got, tc.wantOutput)
+			}
+
+			if tc.wantRequests < 2 {
+				return
+			}
+			var resumed struct {
+				Messages []struct {
+					Role    string            `json:"role"`
+					Content []json.RawMessage `json:"content"`
+				} `json:"messages"`
+			}
+			if err := json.Unmarshal([]byte(requests()[1]), &resumed); err != nil {
+				t.Fatalf("decode continuation request: %v", err)
+			}
+			if n := len(resumed.Messages); n != 2 || resumed.Messages[1].Role != "assistant" {
+				t.Fatalf("continuation messages = %+v, want user turn followed by the paused assistant turn", resumed.Messages)
+			}
+		})
+	}
+}
+
+const (
+	truncatedTextJSON = `{"id":"msg_1","type":"message","role":"assistant","model":"claude-haiku-4-5","content":[{"type":"text","text":"Hello "}],"stop_reason":"max_tokens","usage":{"input_tokens":10,"output_tokens":5}}`
+	continuedTextJSON = `{"id":"msg_2","type":"message","role":"assistant","model":"claude-haiku-4-5","content":[{"type":"text","text":" world."}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`
+)
+
+var truncatedTextStream = []string{
+	`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-haiku-4-5","content":[],"stop_reason":null,"usage":{"input_tokens":10,"output_tokens":0}}}`,
+	`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+	`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello "}}`,
+	`{"type":"content_block_stop","index":0}`,
+	`{"type":"message_delta","delta":{"stop_reason":"max_tokens","stop_sequence":null},"usage":{"output_tokens":5}}`,
+	`{"type":"message_stop"}`,
+}
+
+var continuedTextStream = []string{
+	`{"type":"message_start","message":{"id":"msg_2","type":"message","role":"assistant","model":"claude-haiku-4-5","content":[],"stop_reason":null,"usage":{"input_tokens":12,"output_tokens":0}}}`,
+	`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+	`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" world."}}`,
+	`{"type":"content_block_stop","index":0}`,
+	`{"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":3}}`,
+	`{"type":"message_stop"}`,
+}
+
+func TestMaxTokensContinuation(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		stream       bool
+		budget       int
+		wantRequests int
+		wantDeltas   []string
+		wantText     string
+		wantFinish   genai.FinishReason
+		wantOutput   int32
+	}{
+		{"generate_continued", false, 100, 2, nil, "Hello world.", genai.FinishReasonStop, 8},
+		{"generate_budget_spent", false, 5, 1, nil, "Hello ", genai.FinishReasonMaxTokens, 5},
+		{"stream_continued", true, 100, 2, []string{"Hello ", "world."}, "Hello world.", genai.FinishReasonStop, 8},
+		{"stream_budget_spent", true, 5, 1, []string{"Hello "}, "Hello ", genai.FinishReasonMaxTokens, 5},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var srv *httptest.Server
+			var requests func() []string
+			if tc.stream {
+				srv, requests = newRecordingServer(t, "text/event-stream",
+					sseFromPayloads(t, truncatedTextStream), sseFromPayloads(t, continuedTextStream))
+			} else {
+				srv, requests = newRecordingServer(t, "application/json",
+					truncatedTextJSON, continuedTextJSON)
+			}
+			m, _ := newStreamTestModel(t, srv.URL)
+			m.maxTokensContinuation = &MaxTokensContinuationConfig{TotalOutputTokens: tc.budget}
+
+			req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("greet me", genai.RoleUser)}}
+			var deltas []string
+			var final *model.LLMResponse
+			for resp, err := range m.GenerateContent(t.Context(), req, tc.stream) {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				switch {
+				case !resp.Partial:
+					final = resp
+				case resp.Content != nil:
+					deltas = append(deltas, resp.Content.Parts[0].Text)
+				}
+			}
+
+			if got := len(requests()); got != tc.wantRequests {
+				t.Fatalf("requests = %d, want %d", got, tc.wantRequests)
+			}
+			if !slices.Equal(deltas, tc.wantDeltas) {
+				t.Errorf("deltas = %q, want %q", deltas, tc.wantDeltas)
+			}
+			if len(final.Content.Parts) != 1 || final.Content.Parts[0].Text != tc.wantText {
+				t.Errorf("final parts = %+v, want a single text part %q", final.Content.Parts, tc.wantText)
+			}
+			if final.FinishReason != tc.wantFinish {
+				t.Errorf("FinishReason = %q, want %q", final.FinishReason, tc.wantFinish)
+			}
+			if got := final.UsageMetadata.CandidatesTokenCount; got != tc.wantOutput {
+				t.Errorf("output tokens = %d, want %d", got, tc.wantOutput)
+			}
+
+			if tc.wantRequests < 2 {
+				return
+			}
+			var continued struct {
+				MaxTokens int             `json:"max_tokens"`
+				Thinking  json.RawMessage `json:"thinking"`
+				Messages  []struct {
+					Role    string `json:"role"`
+					Content []struct {
+						Text string `json:"text"`
+					} `json:"content"`
+				} `json:"messages"`
+			}
+			if err := json.Unmarshal([]byte(requests()[1]), &continued); err != nil {
+				t.Fatalf("decode continuation request: %v", err)
+			}
+			if continued.MaxTokens != tc.budget-5 {
+				t.Errorf("max_tokens = %d, want the remaining budget %d", continued.MaxTokens, tc.budget-5)
+			}
+			if continued.Thinking != nil {
+				t.Errorf("thinking = %s, want it omitted alongside a prefill", continued.Thinking)
+			}
+			last := continued.Messages[len(continued.Messages)-1]
+			if last.Role != "assistant" || len(last.Content) != 1 || last.Content[0].Text != "Hello" {
+				t.Errorf("last message = %+v, want the assistant prefill %q without trailing whitespace", last, "Hello")
+			}
+		})
+	}
+}
+
+func TestMaxTokensContinuation_PrefillRejectingModel(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stream=%v", stream), func(t *testing.T) {
+			var srv *httptest.Server
+			var requests func() []string
+			if stream {
+				srv, requests = newRecordingServer(t, "text/event-stream",
+					sseFromPayloads(t, truncatedTextStream), sseFromPayloads(t, continuedTextStream))
+			} else {
+				srv, requests = newRecordingServer(t, "application/json",
+					truncatedTextJSON, continuedTextJSON)
+			}
+			m, _ := newStreamTestModel(t, srv.URL)
+			m.name = "claude-opus-4-6"
+			m.maxTokensContinuation = &MaxTokensContinuationConfig{TotalOutputTokens: 100}
+
+			req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("greet me", genai.RoleUser)}}
+			var final *model.LLMResponse
+			for resp, err := range m.GenerateContent(t.Context(), req, stream) {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !resp.Partial {
+					final = resp
+				}
+			}
+
+			if got := len(requests()); got != 1 {
+				t.Errorf("requests = %d, want 1: the model rejects an assistant prefill", got)
+			}
+			if len(final.Content.Parts) != 1 || final.Content.Parts[0].Text != "Hello " {
+				t.Errorf("final parts = %+v, want the truncated text %q", final.Content.Parts, "Hello ")
+			}
+			if final.FinishReason != genai.FinishReasonMaxTokens {
+				t.Errorf("FinishReason = %q, want %q", final.FinishReason, genai.FinishReasonMaxTokens)
+			}
+		})
+	}
+}
+
+func TestContinuation_FailedRequestKeepsResponse(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		stream     bool
+		body       string
+		wantText   string
+		wantFinish genai.FinishReason
+	}{
+		{"pause_turn_generate", false, pausedMessageJSON, "Searching. ", genai.FinishReasonOther},
+		{"max_tokens_generate", false, truncatedTextJSON, "Hello ", genai.FinishReasonMaxTokens},
+		{"pause_turn_stream", true, "", "Searching. ", genai.FinishReasonOther},
+		{"max_tokens_stream", true, "", "Hello ", genai.FinishReasonMaxTokens},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			body, contentType := tc.body, "application/json"
+			if tc.stream {
+				payloads := pausedStream
+				if strings.HasPrefix(tc.name, "max_tokens") {
+					payloads = truncatedTextStream
+				}
+				body, contentType = sseFromPayloads(t, payloads), "text/event-stream"
+			}
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.Copy(io.Discard, r.Body)
+				if requests.Add(1) > 1 {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusBadRequest)
+					_, _ = io.WriteString(w, `{"type":"error","error":{"type":"invalid_request_error","message":"rejected"}}`)
+					return
+				}
+				w.Header().Set("Content-Type", contentType)
+				_, _ = io.WriteString(w, body)
+			}))
+			t.Cleanup(srv.Close)
+			m, _ := newStreamTestModel(t, srv.URL)
+			m.maxPauseTurnContinuations = 1
+			m.maxTokensContinuation = &MaxTokensContinuationConfig{TotalOutputTokens: 100}
+
+			req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("hello", genai.RoleUser)}}
+			var final *model.LLMResponse
+			var partialFinishes []genai.FinishReason
+			for resp, err := range m.GenerateContent(t.Context(), req, tc.stream) {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !resp.Partial {
+					final = resp
+				} else if resp.FinishReason != "" {
+					partialFinishes = append(partialFinishes, resp.FinishReason)
+				}
+			}
+
+			if got := requests.Load(); got != 2 {
+				t.Errorf("requests = %d, want 2", got)
+			}
+			if final == nil {
+				t.Fatal("no final response")
+			}
+			if len(final.Content.Parts) != 1 || final.Content.Parts[0].Text != tc.wantText {
+				t.Errorf("final parts = %+v, want the response so far %q", final.Content.Parts, tc.wantText)
+			}
+			if final.FinishReason != tc.wantFinish {
+				t.Errorf("FinishReason = %q, want %q", final.FinishReason, tc.wantFinish)
+			}
+			if tc.stream && !slices.Equal(partialFinishes, []genai.FinishReason{tc.wantFinish}) {
+				t.Errorf("partial finish reasons = %q, want the held partial's %q", partialFinishes, tc.wantFinish)
+			}
+		})
+	}
+}
+
+// describePartial summarizes a streamed partial: message partials with their
+// finish reason, stop reason and output tokens, and block markers and text
+// deltas with their block index.
+func describePartial(resp *model.LLMResponse) string {
+	index := resp.CustomMetadata[converters.BlockIndexMetadataKey]
+	switch {
+	case resp.Content != nil:
+		return fmt.Sprintf("text %v", index)
+	case resp.CustomMetadata[converters.BlockEventMetadataKey] != nil:
+		return fmt.Sprintf("%v %v", resp.CustomMetadata[converters.BlockEventMetadataKey], index)
+	default:
+		return fmt.Sprintf("message %q %v %d", resp.FinishReason,
+			resp.CustomMetadata[converters.StopReasonMetadataKey], resp.UsageMetadata.CandidatesTokenCount)
+	}
+}
+
+func TestContinuation_StreamPartials(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		bodies []string
+		setup  func(*anthropicModel)
+		want   []string
+	}{
+		{
+			name:   "pause_turn",
+			bodies: []string{sseFromPayloads(t, pausedStream), sseFromPayloads(t, resumedStream)},
+			setup:  func(m *anthropicModel) { m.maxPauseTurnContinuations = 1 },
+			want: []string{
+				`message "" <nil> 0`,
+				"start 0", "text 0", "stop 0",
+				`message "" <nil> 3`,
+				"start 1", "text 1", "stop 1",
+				`message "STOP" end_turn 7`,
+			},
+		},
+		{
+			name:   "max_tokens",
+			bodies: []string{sseFromPayloads(t, truncatedTextStream), sseFromPayloads(t, continuedTextStream)},
+			setup: func(m *anthropicModel) {
+				m.maxTokensContinuation = &MaxTokensContinuationConfig{TotalOutputTokens: 100}
+			},
+			want: []string{
+				`message "" <nil> 0`,
+				"start 0", "text 0", "stop 0",
+				`message "" <nil> 5`,
+				"start 1", "text 1", "stop 1",
+				`message "STOP" end_turn 8`,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, _ := newRecordingServer(t, "text/event-stream", tc.bodies...)
+			m, _ := newStreamTestModel(t, srv.URL)
+			tc.setup(m)
+
+			req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("go", genai.RoleUser)}}
+			var got []string
+			var final *model.LLMResponse
+			for resp, err := range m.GenerateContent(t.Context(), req, true) {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !resp.Partial {
+					final = resp
+					continue
+				}
+				if final != nil {
+					t.Fatal("partial after the final response")
+				}
+				// The consumer must not see the stream end before it does.
+				if resp.FinishReason == genai.FinishReasonMaxTokens || resp.FinishReason == genai.FinishReasonOther {
+					t.Errorf("partial with FinishReason %q before the final response", resp.FinishReason)
+				}
+				got = append(got, describePartial(resp))
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("partials:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+			}
+			if final == nil || final.FinishReason != genai.FinishReasonStop {
+				t.Errorf("final response = %+v, want FinishReason STOP", final)
+			}
+		})
+	}
+}
+
+func TestContinuation_MergesContextManagement(t *testing.T) {
+	edit := func(tokens int) string {
+		return fmt.Sprintf(`"context_management":{"applied_edits":[{"type":"clear_tool_uses_20250919","cleared_input_tokens":%d,"cleared_tool_uses":1}]}`, tokens)
+	}
+	withEdit := func(message string, tokens int) string {
+		return strings.TrimSuffix(message, "}") + "," + edit(tokens) + "}"
+	}
+	streamWithEdit := func(events []string, tokens int) string {
+		events = slices.Clone(events)
+		events[4] = withEdit(events[4], tokens)
+		return sseFromPayloads(t, events)
+	}
+
+	for _, stream := range []bool{false, true} {
+		t.Run(map[bool]string{false: "unary", true: "stream"}[stream], func(t *testing.T) {
+			bodies, contentType := []string{withEdit(pausedMessageJSON, 100), withEdit(resumedMessageJSON, 200)}, "application/json"
+			if stream {
+				bodies, contentType = []string{streamWithEdit(pausedStream, 100), streamWithEdit(resumedStream, 200)}, "text/event-stream"
+			}
+			srv, _ := newRecordingServer(t, contentType, bodies...)
+			m, _ := newStreamTestModel(t, srv.URL)
+			m.maxPauseTurnContinuations = 1
+
+			req := &model.LLMRequest{Contents: []*genai.Content{genai.NewContentFromText("find it", genai.RoleUser)}}
+			var final *model.LLMResponse
+			for resp, err := range m.GenerateContent(t.Context(), req, stream) {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !resp.Partial {
+					final = resp
+				}
+			}
+
+			edits, _ := final.CustomMetadata[converters.ContextManagementMetadataKey].([]converters.AppliedContextEdit)
+			if len(edits) != 2 || edits[0].ClearedInputTokens != 100 || edits[1].ClearedInputTokens != 200 {
+				t.Errorf("context management metadata = %+v, want the edits of both requests in order", edits)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+// Copyright 2026 Alcova AI
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converters
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// DefaultMaxBlobBytes is the download limit FileDataInliner applies when
+// MaxBytes is zero. It matches Anthropic's 32 MB request size limit.
+const DefaultMaxBlobBytes = 32 << 20
+
+// BlobFetcher downloads the bytes behind a FileData URI so they can be sent
+// inline instead of as a URL source.
+type BlobFetcher interface {
+	// Fetch opens uri for reading and reports its MIME type, or "" when
+	// unknown. The caller closes the returned reader.
+	Fetch(ctx context.Context, uri string) (body io.ReadCloser, mimeType string, err error)
+}
+
+// HTTPBlobFetcher fetches http and https URIs with a GET request.
+type HTTPBlobFetcher struct {
+	// Client sends the requests. Nil means http.DefaultClient.
+	Client *http.Client
+}
+
+// Fetch implements BlobFetcher. A non-2xx response is an error.
+func (f HTTPBlobFetcher) Fetch(ctx context.Context, uri string) (io.ReadCloser, string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, "", fmt.Errorf("unsupported URI scheme %q", u.Scheme)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		_ = resp.Body.Close()
+		return nil, "", fmt.Errorf("GET %s: %s", uri, resp.Status)
+	}
+	return resp.Body, resp.Header.Get("Content-Type"), nil
+}
+
+// GCSObjectOpener opens a Cloud Storage object. It keeps this package free of
+// a Cloud Storage dependency; a *storage.Client adapts to it in a few lines
+// with Bucket(bucket).Object(object).NewReader(ctx) and the reader's
+// Attrs.ContentType.
+type GCSObjectOpener interface {
+	OpenObject(ctx context.Context, bucket, object string) (body io.ReadCloser, contentType string, err error)
+}
+
+// GCSBlobFetcher fetches gs://bucket/object URIs through Opener.
+type GCSBlobFetcher struct {
+	Opener GCSObjectOpener
+}
+
+// Fetch implements BlobFetcher.
+func (f GCSBlobFetcher) Fetch(ctx context.Context, uri string) (io.ReadCloser, string, error) {
+	rest, ok := strings.CutPrefix(uri, "gs://")
+	if !ok {
+		return nil, "", fmt.Errorf("not a gs:// URI: %s", uri)
+	}
+	bucket, object, ok := strings.Cut(rest, "/")
+	if !ok || bucket == "" || object == "" {
+		return nil, "", fmt.Errorf("gs:// URI must name a bucket and an object: %s", uri)
+	}
+	if f.Opener == nil {
+		return nil, "", fmt.Errorf("no Cloud Storage opener configured for %s", uri)
+	}
+	return f.Opener.OpenObject(ctx, bucket, object)
+}
+
+// SchemeBlobFetcher routes each URI to the fetcher registered for its scheme,
+// e.g. {"https": HTTPBlobFetcher{}, "gs": GCSBlobFetcher{...}}.
+type SchemeBlobFetcher map[string]BlobFetcher
+
+// Fetch implements BlobFetcher. A URI whose scheme has no fetcher is an
+// error.
+func (f SchemeBlobFetcher) Fetch(ctx context.Context, uri string) (io.ReadCloser, string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, "", err
+	}
+	fetcher, ok := f[strings.ToLower(u.Scheme)]
+	if !ok {
+		return nil, "", fmt.Errorf("no fetcher for URI scheme %q", u.Scheme)
+	}
+	return fetcher.Fetch(ctx, uri)
+}
+
+// FileDataInliner downloads the image and PDF FileData parts of a
+// conversation and replaces them with InlineData, so they reach Anthropic as
+// base64 sources instead of URLs. Use it for backends that reject URL
+// sources and for URIs Anthropic cannot reach, such as gs:// objects or
+// internal hosts.
+type FileDataInliner struct {
+	// Fetcher downloads each URI.
+	Fetcher BlobFetcher
+
+	// MaxBytes caps the size of each download. Zero means
+	// DefaultMaxBlobBytes.
+	MaxBytes int64
+
+	// Cache, when set, keeps downloads keyed by URI and declared MIME
+	// type, so a file that stays in the conversation is fetched once
+	// rather than on every request. Failed downloads are not cached. When
+	// nil, every request downloads every file again.
+	Cache *BlobCache
+
+	// AnyImageType accepts downloads of every image type rather than only
+	// those Anthropic accepts. Set it when ImageNormalization converts the
+	// rest; conversion still rejects what remains unsupported.
+	AnyImageType bool
+}
+
+// InlineContents returns contents with every image and PDF FileData part,
+// including those nested in FunctionResponse.Parts, replaced by InlineData.
+// Contents and parts it changes are copied; the input is never modified.
+//
+// The downloaded MIME type is checked before inlining: it must be an image
+// type Anthropic accepts (any image type with AnyImageType) or
+// application/pdf. When the source reports no
+// specific type, the part's declared MIMEType is used. Other FileData parts
+// are left as they are.
+//
+// A failed download fails the call, wherever the part is in the history; it
+// does not fall back to a URL source. The inliner is used for backends that
+// reject URL sources and for URIs Anthropic cannot reach, where the fallback
+// would only fail later, server-side and less clearly. Set Cache so that a
+// file fetched once keeps working after its URI expires, for as long as it
+// stays cached.
+func (f FileDataInliner) InlineContents(ctx context.Context, contents []*genai.Content) ([]*genai.Content, error) {
+	var out []*genai.Content
+	for i, content := range contents {
+		inlined, err := f.inlineContent(ctx, content)
+		if err != nil {
+			return nil, err
+		}
+		if inlined == content {
+			continue
+		}
+		if out == nil {
+			out = append([]*genai.Content(nil), contents...)
+		}
+		out[i] = inlined
+	}
+	if out == nil {
+		return contents, nil
+	}
+	return out, nil
+}
+
+// inlineContent returns content itself when no part needed inlining.
+func (f FileDataInliner) inlineContent(ctx context.Context, content *genai.Content) (*genai.Content, error) {
+	if content == nil {
+		return nil, nil
+	}
+	var parts []*genai.Part
+	for i, part := range content.Parts {
+		inlined, err := f.inlinePart(ctx, part)
+		if err != nil {
+			return nil, err
+		}
+		if inlined == part {
+			continue
+		}
+		if parts == nil {
+			parts = append([]*genai.Part(nil), content.Parts...)
+		}
+		parts[i] = inlined
+	}
+	if parts == nil {
+		return content, nil
+	}
+	copied := *content
+	copied.Parts = parts
+	return &copied, nil
+}
+
+// inlinePart returns part itself when it needed no inlining.
+func (f FileDataInliner) inlinePart(ctx context.Context, part *genai.Part) (*genai.Part, error) {
+	switch {
+	case part == nil:
+		return part, nil
+
+	case part.FileData != nil && isURLSourceMIMEType(part.FileData.MIMEType):
+		blob, err := f.fetch(ctx, part.FileData.FileURI, part.FileData.MIMEType)
+		if err != nil {
+			return nil, err
+		}
+		// blob may be shared through the cache, so each part gets its
+		// own copy carrying its display name.
+		copied := *part
+		copied.FileData = nil
+		copied.InlineData = &genai.Blob{Data: blob.Data, MIMEType: blob.MIMEType, DisplayName: part.FileData.DisplayName}
+		return &copied, nil
+
+	case part.FunctionResponse != nil:
+		var parts []*genai.FunctionResponsePart
+		for i, respPart := range part.FunctionResponse.Parts {
+			if respPart == nil || respPart.FileData == nil || !isURLSourceMIMEType(respPart.FileData.MIMEType) {
+				continue
+			}
+			blob, err := f.fetch(ctx, respPart.FileData.FileURI, respPart.FileData.MIMEType)
+			if err != nil {
+				return nil, err
+			}
+			if parts == nil {
+				parts = append([]*genai.FunctionResponsePart(nil), part.FunctionResponse.Parts...)
+			}
+			parts[i] = &genai.FunctionResponsePart{
+				InlineData: &genai.FunctionResponseBlob{
+					Data:        blob.Data,
+					MIMEType:    blob.MIMEType,
+					DisplayName: respPart.FileData.DisplayName,
+				},
+			}
+		}
+		if parts == nil {
+			return part, nil
+		}
+		resp := *part.FunctionResponse
+		resp.Parts = parts
+		copied := *part
+		copied.FunctionResponse = &resp
+		return &copied, nil
+	}
+	return part, nil
+}
+
+// fetch downloads uri under the size and MIME type checks.
+func (f FileDataInliner) fetch(ctx context.Context, uri, declaredMIMEType string) (*genai.Blob, error) {
+	if blob, ok := f.Cache.get(uri, declaredMIMEType); ok {
+		return blob, nil
+	}
+	if f.Fetcher == nil {
+		return nil, fmt.Errorf("no blob fetcher configured for %s", uri)
+	}
+	body, fetchedMIMEType, err := f.Fetcher.Fetch(ctx, uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", uri, err)
+	}
+	defer body.Close()
+
+	mimeType := blobMIMEType(fetchedMIMEType, declaredMIMEType)
+	switch {
+	case mimeType == "application/pdf":
+	case strings.HasPrefix(mimeType, "image/"):
+		if _, err := mapImageMediaType(mimeType); err != nil && !f.AnyImageType {
+			return nil, fmt.Errorf("fetched file %s: %w", uri, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported MIME type for fetched file %s: %s", uri, mimeType)
+	}
+
+	maxBytes := f.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBlobBytes
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", uri, err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("%s exceeds the %d byte limit for inlined files", uri, maxBytes)
+	}
+
+	blob := &genai.Blob{Data: data, MIMEType: mimeType}
+	f.Cache.put(uri, declaredMIMEType, blob)
+	return blob, nil
+}
+
+// blobMIMEType picks the MIME type of a download: the type the source
+// reported, unless it is missing or generic, then the declared one.
+func blobMIMEType(fetched, declared string) string {
+	if mediaType, _, err := mime.ParseMediaType(fetched); err == nil {
+		switch mediaType {
+		case "application/octet-stream", "binary/octet-stream":
+		default:
+			return mediaType
+		}
+	}
+	return strings.ToLower(declared)
+}
+
+// isURLSourceMIMEType reports whether fileDataToBlock sends a part with this
+// MIME type as a URL source.
+func isURLSourceMIMEType(mimeType string) bool {
+	mimeType = strings.ToLower(mimeType)
+	return strings.HasPrefix(mimeType, "image/") || mimeType == "application/pdf"
+}
@@ -0,0 +1,58 @@
+// Copyright 2026 Alcova AI
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converters
+
+import "google.golang.org/genai"
+
+// DefaultBlobCacheBytes is the size of the download cache the model gives its
+// FileDataInliner when FileDataInliningConfig.CacheBytes is zero.
+const DefaultBlobCacheBytes = 64 << 20
+
+// BlobCache keeps downloaded files in memory, up to a total size, evicting
+// the least recently used first. FileDataInliner uses it so that the files of
+// a conversation are downloaded once rather than on every request. It is safe
+// for concurrent use.
+type BlobCache struct {
+	lru *sizedLRU[blobCacheKey, *genai.Blob]
+}
+
+// blobCacheKey identifies a download: the same URI declared with another
+// MIME type may be checked differently.
+type blobCacheKey struct {
+	uri, mimeType string
+}
+
+// NewBlobCache returns a cache holding up to maxBytes of file data. Files
+// larger than maxBytes are not cached.
+func NewBlobCache(maxBytes int64) *BlobCache {
+	return &BlobCache{lru: newSizedLRU[blobCacheKey, *genai.Blob](maxBytes)}
+}
+
+// get returns the cached download of uri declared as mimeType. c may be nil.
+func (c *BlobCache) get(uri, mimeType string) (*genai.Blob, bool) {
+	if c == nil {
+		return nil, false
+	}
+	return c.lru.get(blobCacheKey{uri, mimeType})
+}
+
+// put caches a successful download, evicting older ones to make room. c may
+// be nil.
+func (c *BlobCache) put(uri, mimeType string, blob *genai.Blob) {
+	if c == nil {
+		return
+	}
+	c.lru.put(blobCacheKey{uri, mimeType}, blob, int64(len(blob.Data)))
+}
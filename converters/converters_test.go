@@ -15,13 +15,25 @@
 package converters_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/jsonschema-go/jsonschema"
+	"golang.org/x/image/bmp"
 	"google.golang.org/genai"
 
 	"github.com/Alcova-AI/adk-anthropic-go/v2/converters"
@@ -518,6 +530,9 @@ func TestStopReasonToFinishReason(t *testing.T) {
 		{"max_tokens", anthropic.StopReasonMaxTokens, genai.FinishReasonMaxTokens},
 		{"stop_sequence", anthropic.StopReasonStopSequence, genai.FinishReasonStop},
 		{"tool_use", anthropic.StopReasonToolUse, genai.FinishReasonStop},
+		{"refusal", anthropic.StopReasonRefusal, genai.FinishReasonSafety},
+		{"pause_turn", anthropic.StopReasonPauseTurn, genai.FinishReasonOther},
+		{"model_context_window_exceeded", converters.StopReasonModelContextWindowExceeded, converters.FinishReasonContextWindowExceeded},
 		{"unknown", anthropic.StopReason("unknown"), genai.FinishReasonUnspecified},
 	}
 
@@ -531,6 +546,60 @@ func TestStopReasonToFinishReason(t *testing.T) {
 	}
 }
 
+func TestMessageToLLMResponse_StopReasonMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want map[string]any
+	}{
+		{
+			name: "pause_turn",
+			json: `{"content": [{"type": "text", "text": "Searching"}], "stop_reason": "pause_turn", "usage": {}}`,
+			want: map[string]any{converters.StopReasonMetadataKey: "pause_turn"},
+		},
+		{
+			name: "refusal_with_details",
+			json: `{"content": [], "stop_reason": "refusal", "stop_details": {"type": "refusal", "category": "cyber", "explanation": "Not allowed"}, "usage": {}}`,
+			want: map[string]any{
+				converters.StopReasonMetadataKey:         "refusal",
+				converters.RefusalCategoryMetadataKey:    "cyber",
+				converters.RefusalExplanationMetadataKey: "Not allowed",
+			},
+		},
+		{
+			name: "context_window_exceeded",
+			json: `{"content": [{"type": "text", "text": "Partial"}], "stop_reason": "model_context_window_exceeded", "usage": {}}`,
+			want: map[string]any{converters.StopReasonMetadataKey: "model_context_window_exceeded"},
+		},
+		{
+			name: "stop_sequence_with_message_id",
+			json: `{"id": "msg_1", "content": [{"type": "text", "text": "Done"}], "stop_reason": "stop_sequence", "stop_sequence": "END", "usage": {}}`,
+			want: map[string]any{
+				converters.MessageIDMetadataKey:    "msg_1",
+				converters.StopReasonMetadataKey:   "stop_sequence",
+				converters.StopSequenceMetadataKey: "END",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msg anthropic.Message
+			if err := msg.UnmarshalJSON([]byte(tt.json)); err != nil {
+				t.Fatalf("failed to unmarshal message: %v", err)
+			}
+
+			resp, err := converters.MessageToLLMResponse(&msg)
+			if err != nil {
+				t.Fatalf("MessageToLLMResponse() error = %v", err)
+			}
+			if diff := cmp.Diff(tt.want, resp.CustomMetadata); diff != "" {
+				t.Errorf("CustomMetadata mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestUsageToMetadata(t *testing.T) {
 	usage := anthropic.Usage{InputTokens: 10, OutputTokens: 20}
 	want := &genai.GenerateContentResponseUsageMetadata{
@@ -724,6 +793,79 @@ func TestFunctionResponseToBlock_PreservesInlineParts(t *testing.T) {
 	}
 }
 
+func TestPartToContentBlock_TextDocuments(t *testing.T) {
+	docx := "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	extractor := converters.TextExtractorFunc(func(data []byte, mimeType string) (string, bool, error) {
+		if mimeType != docx {
+			return "", false, nil
+		}
+		return "extracted: " + string(data), true, nil
+	})
+
+	type document struct {
+		Text  string
+		Title string
+	}
+	for _, tc := range []struct {
+		name      string
+		blob      genai.Blob
+		extractor converters.TextExtractor
+		want      document
+		wantErr   string
+	}{
+		{"plain_text", genai.Blob{Data: []byte("hello"), MIMEType: "text/plain"}, nil, document{Text: "hello"}, ""},
+		{"markdown_titled", genai.Blob{Data: []byte("# Notes"), MIMEType: "text/markdown", DisplayName: "notes.md"}, nil, document{"# Notes", "notes.md"}, ""},
+		{"csv_with_charset", genai.Blob{Data: []byte("a,b\n1,2"), MIMEType: "text/csv; charset=UTF-8"}, nil, document{Text: "a,b\n1,2"}, ""},
+		{"json", genai.Blob{Data: []byte(`{"a":1}`), MIMEType: "application/json"}, nil, document{Text: `{"a":1}`}, ""},
+		{"structured_suffix", genai.Blob{Data: []byte(`{"@id":"x"}`), MIMEType: "application/ld+json"}, nil, document{Text: `{"@id":"x"}`}, ""},
+		{"extracted_office_document", genai.Blob{Data: []byte("report"), MIMEType: docx, DisplayName: "report.docx"}, extractor, document{"extracted: report", "report.docx"}, ""},
+		{"invalid_utf8", genai.Blob{Data: []byte{0xff, 0xfe}, MIMEType: "text/plain"}, nil, document{}, "not valid UTF-8"},
+		{"other_charset", genai.Blob{Data: []byte("hello"), MIMEType: "text/plain; charset=latin1"}, nil, document{}, "unsupported charset"},
+		{"office_without_extractor", genai.Blob{Data: []byte("report"), MIMEType: docx}, nil, document{}, "unsupported MIME type"},
+		{"extractor_declines", genai.Blob{Data: []byte("zip"), MIMEType: "application/zip"}, extractor, document{}, "unsupported MIME type"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			contents := []*genai.Content{{Role: genai.RoleUser, Parts: []*genai.Part{{InlineData: &tc.blob}}}}
+			messages, _, err := converters.ContentsToMessagesWithOptions(contents, converters.ConvertOptions{TextExtractor: tc.extractor})
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want it to mention %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ContentsToMessagesWithOptions: %v", err)
+			}
+			doc := messages[0].Content[0].OfDocument
+			if doc == nil || doc.Source.OfText == nil {
+				t.Fatalf("block = %#v, want a plain-text document", messages[0].Content[0])
+			}
+			got := document{Text: doc.Source.OfText.Data, Title: doc.Title.Value}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("document mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("function_response_parts", func(t *testing.T) {
+		part := &genai.Part{FunctionResponse: &genai.FunctionResponse{
+			ID:   "call_1",
+			Name: "read_file",
+			Parts: []*genai.FunctionResponsePart{{
+				InlineData: &genai.FunctionResponseBlob{Data: []byte("col\n1"), MIMEType: "text/csv", DisplayName: "data.csv"},
+			}},
+		}}
+		block, err := converters.PartToContentBlock(part)
+		if err != nil {
+			t.Fatalf("PartToContentBlock: %v", err)
+		}
+		doc := block.OfToolResult.Content[0].OfDocument
+		if doc == nil || doc.Source.OfText == nil || doc.Source.OfText.Data != "col\n1" || doc.Title.Value != "data.csv" {
+			t.Errorf("tool result content = %#v, want a titled plain-text document", block.OfToolResult.Content[0])
+		}
+	})
+}
+
 func TestFunctionResponseToBlock_ParallelResultsStayContiguous(t *testing.T) {
 	content := &genai.Content{
 		Role: "user",
@@ -1277,6 +1419,639 @@ func TestMessageToLLMResponse_WithCitations(t *testing.T) {
 	}
 }
 
+func TestMessageToLLMResponse_DocumentCitations(t *testing.T) {
+	msgJSON := `{
+		"content": [
+			{"type": "text", "text": "Revenue grew."},
+			{
+				"type": "text",
+				"text": "It rose 12%.",
+				"citations": [
+					{"type": "page_location", "cited_text": "Revenue rose 12%", "document_index": 1, "document_title": "Q3 report", "start_page_number": 3, "end_page_number": 4},
+					{"type": "char_location", "cited_text": "up 12%", "document_index": 0, "document_title": "Notes", "start_char_index": 10, "end_char_index": 16}
+				]
+			},
+			{
+				"type": "text",
+				"text": "Margins held.",
+				"citations": [
+					{"type": "content_block_location", "cited_text": "Margins were flat", "document_index": 2, "start_block_index": 1, "end_block_index": 2}
+				]
+			}
+		],
+		"stop_reason": "end_turn",
+		"usage": {}
+	}`
+
+	var msg anthropic.Message
+	if err := msg.UnmarshalJSON([]byte(msgJSON)); err != nil {
+		t.Fatalf("failed to unmarshal message: %v", err)
+	}
+	resp, err := converters.MessageToLLMResponse(&msg)
+	if err != nil {
+		t.Fatalf("MessageToLLMResponse() error = %v", err)
+	}
+
+	wantCitations := []*genai.Citation{
+		{Title: "Q3 report"},
+		{Title: "Notes", StartIndex: 10, EndIndex: 16},
+		{},
+	}
+	if diff := cmp.Diff(wantCitations, resp.CitationMetadata.Citations); diff != "" {
+		t.Errorf("citations mismatch (-want +got):\n%s", diff)
+	}
+
+	wantDetails := []converters.CitationDetail{
+		{Type: "page_location", PartIndex: 1, CitedText: "Revenue rose 12%", DocumentIndex: 1, DocumentTitle: "Q3 report", Start: 3, End: 4},
+		{Type: "char_location", PartIndex: 1, CitedText: "up 12%", DocumentIndex: 0, DocumentTitle: "Notes", Start: 10, End: 16},
+		{Type: "content_block_location", PartIndex: 2, CitedText: "Margins were flat", DocumentIndex: 2, Start: 1, End: 2},
+	}
+	if diff := cmp.Diff(wantDetails, resp.CustomMetadata[converters.CitationsMetadataKey]); diff != "" {
+		t.Errorf("citation details mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestContentsToMessagesWithOptions_EnablesCitations(t *testing.T) {
+	pdf := genai.NewPartFromBytes([]byte("%PDF"), "application/pdf")
+	text := genai.NewPartFromBytes([]byte("notes"), "text/plain")
+	optedIn := genai.NewPartFromURI("https://example.com/a.pdf", "application/pdf")
+	optedIn.PartMetadata = map[string]any{converters.CitationsEnabledMetadataKey: true}
+	optedOut := genai.NewPartFromBytes([]byte("%PDF"), "application/pdf")
+	optedOut.PartMetadata = map[string]any{converters.CitationsEnabledMetadataKey: false}
+	image := genai.NewPartFromBytes([]byte("png"), "image/png")
+	toolResult := &genai.Part{FunctionResponse: &genai.FunctionResponse{
+		ID:    "call_1",
+		Name:  "fetch",
+		Parts: []*genai.FunctionResponsePart{genai.NewFunctionResponsePartFromBytes([]byte("%PDF"), "application/pdf")},
+	}}
+
+	citationsOf := func(t *testing.T, opts converters.ConvertOptions) []bool {
+		t.Helper()
+		contents := []*genai.Content{
+			genai.NewContentFromParts([]*genai.Part{pdf, text, optedIn, optedOut, image}, genai.RoleUser),
+			genai.NewContentFromParts([]*genai.Part{toolResult}, genai.RoleUser),
+		}
+		messages, _, err := converters.ContentsToMessagesWithOptions(contents, opts)
+		if err != nil {
+			t.Fatalf("ContentsToMessagesWithOptions: %v", err)
+		}
+		var got []bool
+		for _, block := range messages[0].Content {
+			if block.OfDocument != nil {
+				got = append(got, block.OfDocument.Citations.Enabled.Value)
+			}
+		}
+		for _, block := range messages[0].Content[len(messages[0].Content)-1].OfToolResult.Content {
+			got = append(got, block.OfDocument.Citations.Enabled.Value)
+		}
+		return got
+	}
+
+	// pdf, text, opted in, opted out, then the tool result's PDF.
+	if diff := cmp.Diff([]bool{false, false, true, false, false}, citationsOf(t, converters.ConvertOptions{})); diff != "" {
+		t.Errorf("per-part citations mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]bool{true, true, true, false, true}, citationsOf(t, converters.ConvertOptions{Citations: true})); diff != "" {
+		t.Errorf("per-request citations mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestContentsToMessages_SearchResults(t *testing.T) {
+	response := converters.SearchResultsResponse(
+		converters.SearchResult{Source: "https://example.com/a", Title: "A", Content: []string{"first", "second"}},
+		converters.SearchResult{Source: "https://example.com/b", Title: "B", Content: []string{"third"}},
+	)
+	response["query"] = "growth"
+
+	// Session storage round-trips responses through JSON; the stored shape
+	// must convert the same way.
+	raw, err := json.Marshal(response)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	var stored map[string]any
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+
+	for name, response := range map[string]map[string]any{"built": response, "stored": stored} {
+		t.Run(name, func(t *testing.T) {
+			contents := []*genai.Content{genai.NewContentFromParts([]*genai.Part{
+				genai.NewPartFromFunctionResponse("search", response),
+			}, genai.RoleUser)}
+			contents[0].Parts[0].FunctionResponse.ID = "call_1"
+
+			messages, err := converters.ContentsToMessages(contents)
+			if err != nil {
+				t.Fatalf("ContentsToMessages: %v", err)
+			}
+			content := messages[0].Content[0].OfToolResult.Content
+			if len(content) != 3 {
+				t.Fatalf("got %d tool result blocks, want 3", len(content))
+			}
+			if got, want := content[0].OfText.Text, `{"query":"growth"}`; got != want {
+				t.Errorf("remaining response = %s, want %s", got, want)
+			}
+
+			type result struct {
+				Source, Title string
+				Content       []string
+				Citations     bool
+			}
+			var got []result
+			for _, block := range content[1:] {
+				r := result{
+					Source:    block.OfSearchResult.Source,
+					Title:     block.OfSearchResult.Title,
+					Citations: block.OfSearchResult.Citations.Enabled.Value,
+				}
+				for _, text := range block.OfSearchResult.Content {
+					r.Content = append(r.Content, text.Text)
+				}
+				got = append(got, r)
+			}
+			want := []result{
+				{Source: "https://example.com/a", Title: "A", Content: []string{"first", "second"}, Citations: true},
+				{Source: "https://example.com/b", Title: "B", Content: []string{"third"}, Citations: true},
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("search results mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	invalid := map[string]map[string]any{
+		"not a list":    {converters.SearchResultsResponseKey: "passage"},
+		"missing title": {converters.SearchResultsResponseKey: []any{map[string]any{"source": "s", "content": "c"}}},
+		"empty content": {converters.SearchResultsResponseKey: []any{map[string]any{"source": "s", "title": "t", "content": []any{}}}},
+		"non-string":    {converters.SearchResultsResponseKey: []any{map[string]any{"source": "s", "title": "t", "content": []any{1}}}},
+	}
+	for name, response := range invalid {
+		t.Run(name, func(t *testing.T) {
+			contents := []*genai.Content{genai.NewContentFromParts([]*genai.Part{
+				genai.NewPartFromFunctionResponse("search", response),
+			}, genai.RoleUser)}
+			if _, err := converters.ContentsToMessages(contents); err == nil {
+				t.Error("ContentsToMessages succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestMessageToLLMResponse_SearchResultCitations(t *testing.T) {
+	msgJSON := `{
+		"content": [{
+			"type": "text",
+			"text": "Growth was 12%.",
+			"citations": [{
+				"type": "search_result_location",
+				"cited_text": "grew 12%",
+				"search_result_index": 1,
+				"source": "https://example.com/b",
+				"title": "B",
+				"start_block_index": 0,
+				"end_block_index": 1
+			}]
+		}],
+		"stop_reason": "end_turn",
+		"usage": {}
+	}`
+
+	var msg anthropic.Message
+	if err := msg.UnmarshalJSON([]byte(msgJSON)); err != nil {
+		t.Fatalf("failed to unmarshal message: %v", err)
+	}
+	resp, err := converters.MessageToLLMResponse(&msg)
+	if err != nil {
+		t.Fatalf("MessageToLLMResponse() error = %v", err)
+	}
+
+	want := []*genai.Citation{{Title: "B", URI: "https://example.com/b"}}
+	if diff := cmp.Diff(want, resp.CitationMetadata.Citations); diff != "" {
+		t.Errorf("citations mismatch (-want +got):\n%s", diff)
+	}
+	details, _ := resp.CustomMetadata[converters.CitationsMetadataKey].([]converters.CitationDetail)
+	if len(details) != 1 || details[0].SearchResultIndex != 1 || details[0].Source != "https://example.com/b" || details[0].CitedText != "grew 12%" {
+		t.Errorf("citation details = %+v", details)
+	}
+}
+
+func TestContentsToMessagesWithOptions_ToolErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		response map[string]any
+		detector converters.ToolErrorDetector
+		want     bool
+	}{
+		{name: "success", response: map[string]any{"result": "ok"}, want: false},
+		{name: "ADK error", response: map[string]any{"error": "connection refused"}, want: true},
+		{name: "error with result", response: map[string]any{"result": false, "error": "cannot divide by zero"}, want: true},
+		{name: "structured error", response: map[string]any{"error": map[string]any{"code": 404}}, want: true},
+		{name: "empty error", response: map[string]any{"result": "ok", "error": ""}, want: false},
+		{name: "nil error", response: map[string]any{"result": "ok", "error": nil}, want: false},
+		{name: "false error", response: map[string]any{"error": false}, want: false},
+		{
+			name:     "custom detector",
+			response: map[string]any{"status": "failed"},
+			detector: func(resp *genai.FunctionResponse) bool { return resp.Response["status"] == "failed" },
+			want:     true,
+		},
+		{
+			name:     "custom detector ignores ADK errors",
+			response: map[string]any{"error": "boom"},
+			detector: func(*genai.FunctionResponse) bool { return false },
+			want:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			part := genai.NewPartFromFunctionResponse("lookup", tt.response)
+			part.FunctionResponse.ID = "call_1"
+			contents := []*genai.Content{genai.NewContentFromParts([]*genai.Part{part}, genai.RoleUser)}
+
+			messages, _, err := converters.ContentsToMessagesWithOptions(contents, converters.ConvertOptions{ToolErrorDetector: tt.detector})
+			if err != nil {
+				t.Fatalf("ContentsToMessagesWithOptions: %v", err)
+			}
+			if got := messages[0].Content[0].OfToolResult.IsError.Value; got != tt.want {
+				t.Errorf("IsError = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContentsToMessagesWithOptions_UnwrapsToolResults(t *testing.T) {
+	type block struct{ Kind, Text string }
+	convert := func(t *testing.T, unwrapping *converters.ToolResultUnwrapping, resp *genai.FunctionResponse) []block {
+		t.Helper()
+		resp.ID = "call_1"
+		contents := []*genai.Content{genai.NewContentFromParts([]*genai.Part{{FunctionResponse: resp}}, genai.RoleUser)}
+		messages, _, err := converters.ContentsToMessagesWithOptions(contents, converters.ConvertOptions{ToolResultUnwrapping: unwrapping})
+		if err != nil {
+			t.Fatalf("ContentsToMessagesWithOptions: %v", err)
+		}
+		var got []block
+		for _, b := range messages[0].Content[0].OfToolResult.Content {
+			switch {
+			case b.OfText != nil:
+				got = append(got, block{"text", b.OfText.Text})
+			case b.OfDocument != nil:
+				got = append(got, block{"document", b.OfDocument.Source.OfText.Data})
+			}
+		}
+		return got
+	}
+
+	markdown := "# Report\n\n\"Quoted\" figures"
+	textPart := genai.NewFunctionResponsePartFromBytes([]byte("attached notes"), "text/markdown")
+	tests := []struct {
+		name       string
+		unwrapping *converters.ToolResultUnwrapping
+		resp       *genai.FunctionResponse
+		want       []block
+	}{
+		{
+			name: "disabled",
+			resp: &genai.FunctionResponse{Name: "report", Response: map[string]any{"result": markdown}, Parts: []*genai.FunctionResponsePart{textPart}},
+			want: []block{{"text", `{"result":"# Report\n\n\"Quoted\" figures"}`}, {"document", "attached notes"}},
+		},
+		{
+			name:       "single string",
+			unwrapping: &converters.ToolResultUnwrapping{SingleString: true},
+			resp:       &genai.FunctionResponse{Name: "report", Response: map[string]any{"summary": markdown}},
+			want:       []block{{"text", markdown}},
+		},
+		{
+			name:       "single non-string stays JSON",
+			unwrapping: &converters.ToolResultUnwrapping{SingleString: true},
+			resp:       &genai.FunctionResponse{Name: "count", Response: map[string]any{"result": 3}},
+			want:       []block{{"text", `{"result":3}`}},
+		},
+		{
+			name:       "configured keys",
+			unwrapping: &converters.ToolResultUnwrapping{Keys: []string{"output", "result"}},
+			resp:       &genai.FunctionResponse{Name: "run", Response: map[string]any{"result": "done", "output": markdown, "exit_code": 0}},
+			want:       []block{{"text", `{"exit_code":0}`}, {"text", markdown}, {"text", "done"}},
+		},
+		{
+			name:       "structured key value stays JSON",
+			unwrapping: &converters.ToolResultUnwrapping{SingleString: true, Keys: []string{"result"}},
+			resp:       &genai.FunctionResponse{Name: "lookup", Response: map[string]any{"result": map[string]any{"id": 1}}},
+			want:       []block{{"text", `{"result":{"id":1}}`}},
+		},
+		{
+			name:       "empty string stays JSON",
+			unwrapping: &converters.ToolResultUnwrapping{SingleString: true, Keys: []string{"result"}},
+			resp:       &genai.FunctionResponse{Name: "noop", Response: map[string]any{"result": ""}},
+			want:       []block{{"text", `{"result":""}`}},
+		},
+		{
+			name:       "part text",
+			unwrapping: &converters.ToolResultUnwrapping{PartText: true},
+			resp:       &genai.FunctionResponse{Name: "report", Parts: []*genai.FunctionResponsePart{textPart}},
+			want:       []block{{"text", "attached notes"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, convert(t, tt.unwrapping, tt.resp)); diff != "" {
+				t.Errorf("tool result content mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestContentsToMessagesWithOptions_HistorySanitizer(t *testing.T) {
+	call := func(id string) *genai.Part {
+		return &genai.Part{FunctionCall: &genai.FunctionCall{ID: id, Name: "lookup", Args: map[string]any{}}}
+	}
+	result := func(id string) *genai.Part {
+		return &genai.Part{FunctionResponse: &genai.FunctionResponse{ID: id, Name: "lookup", Response: map[string]any{"ok": true}}}
+	}
+	thought := &genai.Part{Text: "thinking", Thought: true, ThoughtSignature: []byte("sig")}
+	model := func(parts ...*genai.Part) *genai.Content { return genai.NewContentFromParts(parts, genai.RoleModel) }
+	user := func(parts ...*genai.Part) *genai.Content { return genai.NewContentFromParts(parts, genai.RoleUser) }
+
+	// describe renders messages as one line per message.
+	describe := func(messages []anthropic.MessageParam) []string {
+		var lines []string
+		for _, msg := range messages {
+			line := string(msg.Role) + ":"
+			for _, block := range msg.Content {
+				switch {
+				case block.OfText != nil:
+					line += " text(" + block.OfText.Text + ")"
+				case block.OfThinking != nil:
+					line += " thinking"
+				case block.OfToolUse != nil:
+					line += " use(" + block.OfToolUse.ID + ")"
+				case block.OfToolResult != nil:
+					kind := "result("
+					if block.OfToolResult.IsError.Value {
+						kind = "error("
+					}
+					line += " " + kind + block.OfToolResult.ToolUseID + ")"
+				}
+			}
+			lines = append(lines, line)
+		}
+		return lines
+	}
+
+	tests := []struct {
+		name     string
+		contents []*genai.Content
+		want     []string
+		repairs  []converters.HistoryRepair
+	}{
+		{
+			name:     "dangling call at the end",
+			contents: []*genai.Content{user(genai.NewPartFromText("hi")), model(call("a"), call("b")), user(result("a"))},
+			want:     []string{"user: text(hi)", "assistant: use(a) use(b)", "user: result(a) error(b)"},
+			repairs:  []converters.HistoryRepair{{Action: converters.HistoryRepairSynthesizedResult, ToolUseID: "b", ToolName: "lookup", ContentIndex: 1}},
+		},
+		{
+			name:     "cancelled run followed by a new prompt",
+			contents: []*genai.Content{model(call("a")), user(genai.NewPartFromText("never mind"))},
+			want:     []string{"assistant: use(a)", "user: error(a) text(never mind)"},
+			repairs:  []converters.HistoryRepair{{Action: converters.HistoryRepairSynthesizedResult, ToolUseID: "a", ToolName: "lookup", ContentIndex: 0}},
+		},
+		{
+			name:     "call is the last content",
+			contents: []*genai.Content{user(genai.NewPartFromText("hi")), model(call("a"))},
+			want:     []string{"user: text(hi)", "assistant: use(a)", "user: error(a)"},
+			repairs:  []converters.HistoryRepair{{Action: converters.HistoryRepairSynthesizedResult, ToolUseID: "a", ToolName: "lookup", ContentIndex: 1}},
+		},
+		{
+			name:     "orphaned and duplicate results",
+			contents: []*genai.Content{user(result("x")), model(call("a")), user(result("a")), user(result("a"), genai.NewPartFromText("next"))},
+			want:     []string{"assistant: use(a)", "user: result(a) text(next)"},
+			repairs: []converters.HistoryRepair{
+				{Action: converters.HistoryRepairDroppedResult, ToolUseID: "x", ContentIndex: 0},
+				{Action: converters.HistoryRepairDroppedResult, ToolUseID: "a", ContentIndex: 3},
+			},
+		},
+		{
+			name:     "result after other content",
+			contents: []*genai.Content{model(call("a")), user(genai.NewPartFromText("note")), user(result("a"))},
+			want:     []string{"assistant: use(a)", "user: result(a) text(note)"},
+			repairs:  []converters.HistoryRepair{{Action: converters.HistoryRepairMovedResult, ToolUseID: "a", ContentIndex: 2}},
+		},
+		{
+			name:     "call before a thinking boundary",
+			contents: []*genai.Content{user(genai.NewPartFromText("hi")), model(thought, call("a")), model(thought, genai.NewPartFromText("done"))},
+			want:     []string{"user: text(hi)", "assistant: thinking use(a)", "user: error(a)", "assistant: thinking text(done)"},
+			repairs:  []converters.HistoryRepair{{Action: converters.HistoryRepairSynthesizedResult, ToolUseID: "a", ToolName: "lookup", ContentIndex: 1}},
+		},
+		{
+			name:     "valid history is unchanged",
+			contents: []*genai.Content{user(genai.NewPartFromText("hi")), model(call("a")), user(result("a")), model(genai.NewPartFromText("done"))},
+			want:     []string{"user: text(hi)", "assistant: use(a)", "user: result(a)", "assistant: text(done)"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var repairs []converters.HistoryRepair
+			sanitizer := &converters.HistorySanitizer{OnRepair: func(r converters.HistoryRepair) { repairs = append(repairs, r) }}
+			messages, _, err := converters.ContentsToMessagesWithOptions(tt.contents, converters.ConvertOptions{HistorySanitizer: sanitizer})
+			if err != nil {
+				t.Fatalf("ContentsToMessagesWithOptions: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, describe(messages)); diff != "" {
+				t.Errorf("messages mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.repairs, repairs); diff != "" {
+				t.Errorf("repairs mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("interrupted result text", func(t *testing.T) {
+		sanitizer := &converters.HistorySanitizer{InterruptedResult: "cancelled by the user"}
+		messages, _, err := converters.ContentsToMessagesWithOptions([]*genai.Content{model(call("a"))}, converters.ConvertOptions{HistorySanitizer: sanitizer})
+		if err != nil {
+			t.Fatalf("ContentsToMessagesWithOptions: %v", err)
+		}
+		if got := messages[1].Content[0].OfToolResult.Content[0].OfText.Text; got != "cancelled by the user" {
+			t.Errorf("synthesized result = %q, want the configured text", got)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		contents := []*genai.Content{model(thought, call("a")), model(thought, genai.NewPartFromText("done"))}
+		if _, err := converters.ContentsToMessages(contents); err == nil {
+			t.Error("ContentsToMessages succeeded, want the thinking boundary error without a sanitizer")
+		}
+	})
+}
+
+func TestContentsToMessagesWithOptions_Roles(t *testing.T) {
+	toolResult := &genai.Part{FunctionResponse: &genai.FunctionResponse{ID: "call_1", Name: "lookup", Response: map[string]any{"ok": true}}}
+	contents := []*genai.Content{
+		{Role: "system", Parts: []*genai.Part{genai.NewPartFromText("Be brief.")}},
+		{Role: "", Parts: []*genai.Part{genai.NewPartFromText("look it up")}},
+		{Role: "assistant", Parts: []*genai.Part{{FunctionCall: &genai.FunctionCall{ID: "call_1", Name: "lookup"}}}},
+		{Role: "tool", Parts: []*genai.Part{toolResult}},
+		{Role: "function", Parts: []*genai.Part{genai.NewPartFromText("raw tool output")}},
+		{Role: "SYSTEM", Parts: []*genai.Part{genai.NewPartFromText("Cite sources.")}},
+	}
+
+	messages, report, err := converters.ContentsToMessagesWithOptions(contents, converters.ConvertOptions{})
+	if err != nil {
+		t.Fatalf("ContentsToMessagesWithOptions: %v", err)
+	}
+	var roles []anthropic.MessageParamRole
+	for _, msg := range messages {
+		roles = append(roles, msg.Role)
+	}
+	wantRoles := []anthropic.MessageParamRole{anthropic.MessageParamRoleUser, anthropic.MessageParamRoleAssistant, anthropic.MessageParamRoleUser}
+	if diff := cmp.Diff(wantRoles, roles); diff != "" {
+		t.Errorf("roles mismatch (-want +got):\n%s", diff)
+	}
+	if got := len(messages[2].Content); got != 2 {
+		t.Errorf("tool and function contents merged into %d blocks, want 2", got)
+	}
+	var system []string
+	for _, block := range report.System {
+		system = append(system, block.Text)
+	}
+	if diff := cmp.Diff([]string{"Be brief.", "Cite sources."}, system); diff != "" {
+		t.Errorf("hoisted system mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := converters.ContentsToMessages(contents); err == nil {
+		t.Error("ContentsToMessages succeeded, want an error as it cannot return the system prompt")
+	}
+
+	for _, role := range []string{"system", "tool", "function", ""} {
+		t.Run("strict "+role, func(t *testing.T) {
+			strict := []*genai.Content{{Role: role, Parts: []*genai.Part{genai.NewPartFromText("hi")}}}
+			_, _, err := converters.ContentsToMessagesWithOptions(strict, converters.ConvertOptions{StrictRoles: true})
+			if err == nil || !strings.Contains(err.Error(), "unsupported role") {
+				t.Errorf("err = %v, want unsupported role", err)
+			}
+		})
+	}
+
+	t.Run("non-text system part", func(t *testing.T) {
+		system := []*genai.Content{{Role: "system", Parts: []*genai.Part{genai.NewPartFromBytes([]byte("png"), "image/png")}}}
+		if _, _, err := converters.ContentsToMessagesWithOptions(system, converters.ConvertOptions{}); err == nil {
+			t.Error("ContentsToMessagesWithOptions succeeded, want an error for an image in a system-role content")
+		}
+	})
+}
+
+func TestHistoryTrimmers(t *testing.T) {
+	text := func(role genai.Role, s string) *genai.Content { return genai.NewContentFromText(s, role) }
+	call := genai.NewContentFromParts([]*genai.Part{{FunctionCall: &genai.FunctionCall{ID: "c1", Name: "lookup"}}}, genai.RoleModel)
+	result := genai.NewContentFromParts([]*genai.Part{{FunctionResponse: &genai.FunctionResponse{ID: "c1", Name: "lookup", Response: map[string]any{"ok": true}}}}, genai.RoleUser)
+	thinking := genai.NewContentFromParts([]*genai.Part{{Text: "hmm", Thought: true, ThoughtSignature: []byte("sig")}, genai.NewPartFromText("answer two")}, genai.RoleModel)
+	// Three turns, each starting at a prompt; the system content belongs
+	// to none and is always kept.
+	contents := []*genai.Content{
+		text("system", "Be brief."),
+		text(genai.RoleUser, "task"),
+		text(genai.RoleModel, "answer one"),
+		text(genai.RoleUser, strings.Repeat("long ", 100)),
+		call,
+		result,
+		thinking,
+		text(genai.RoleUser, "last question"),
+		text(genai.RoleModel, "last answer"),
+	}
+	describe := func(kept []*genai.Content) []int {
+		var indices []int
+		for _, k := range kept {
+			for i, c := range contents {
+				if k == c {
+					indices = append(indices, i)
+				}
+			}
+		}
+		return indices
+	}
+
+	tests := []struct {
+		name    string
+		trimmer converters.HistoryTrimmer
+		want    []int
+	}{
+		{"last turns", converters.LastTurnsTrimmer{Turns: 2}, []int{0, 3, 4, 5, 6, 7, 8}},
+		{"last turn", converters.LastTurnsTrimmer{Turns: 1}, []int{0, 7, 8}},
+		{"last turns zero", converters.LastTurnsTrimmer{}, []int{0, 1, 2, 3, 4, 5, 6, 7, 8}},
+		{"last turns more than present", converters.LastTurnsTrimmer{Turns: 5}, []int{0, 1, 2, 3, 4, 5, 6, 7, 8}},
+		{"token budget drops the long turn", converters.TokenBudgetTrimmer{MaxTokens: 50}, []int{0, 7, 8}},
+		{"token budget fits all", converters.TokenBudgetTrimmer{MaxTokens: 10_000}, []int{0, 1, 2, 3, 4, 5, 6, 7, 8}},
+		{"token budget keeps the last turn", converters.TokenBudgetTrimmer{MaxTokens: 1}, []int{0, 7, 8}},
+		{
+			"token budget custom estimate",
+			converters.TokenBudgetTrimmer{MaxTokens: 7, Estimate: func(*genai.Content) int { return 1 }},
+			[]int{0, 3, 4, 5, 6, 7, 8},
+		},
+		{"first turn and tail", converters.KeepFirstTurnTrimmer{Tail: converters.LastTurnsTrimmer{Turns: 1}}, []int{0, 1, 2, 7, 8}},
+		{"first turn without tail", converters.KeepFirstTurnTrimmer{}, []int{0, 1, 2, 3, 4, 5, 6, 7, 8}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, describe(tt.trimmer.Trim(contents))); diff != "" {
+				t.Errorf("kept contents mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("report", func(t *testing.T) {
+		kept, report := converters.TrimContents(converters.LastTurnsTrimmer{Turns: 1}, contents)
+		if diff := cmp.Diff([]int{0, 7, 8}, describe(kept)); diff != "" {
+			t.Errorf("kept contents mismatch (-want +got):\n%s", diff)
+		}
+		if report == nil {
+			t.Fatal("report = nil, want the dropped contents")
+		}
+		if diff := cmp.Diff([]int{1, 2, 3, 4, 5, 6}, report.DroppedContents); diff != "" {
+			t.Errorf("dropped contents mismatch (-want +got):\n%s", diff)
+		}
+		if report.OriginalContents != len(contents) || report.DroppedTokens <= 0 {
+			t.Errorf("report = %+v", report)
+		}
+
+		if _, report := converters.TrimContents(converters.LastTurnsTrimmer{Turns: 3}, contents); report != nil {
+			t.Errorf("report = %+v, want nil when nothing is dropped", report)
+		}
+	})
+}
+
+func TestMessageToLLMResponse_ContextManagement(t *testing.T) {
+	var msg anthropic.Message
+	raw := `{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-5","content":[{"type":"text","text":"ok"}],"stop_reason":"end_turn","usage":{"input_tokens":1,"output_tokens":1},` +
+		`"context_management":{"applied_edits":[{"type":"clear_thinking_20251015","cleared_input_tokens":900,"cleared_thinking_turns":2},{"type":"clear_tool_uses_20250919","cleared_input_tokens":4000,"cleared_tool_uses":5}]}}`
+	if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	resp, err := converters.MessageToLLMResponse(&msg)
+	if err != nil {
+		t.Fatalf("converters.MessageToLLMResponse: %v", err)
+	}
+	want := []converters.AppliedContextEdit{
+		{Type: "clear_thinking_20251015", ClearedInputTokens: 900, ClearedThinkingTurns: 2},
+		{Type: "clear_tool_uses_20250919", ClearedInputTokens: 4000, ClearedToolUses: 5},
+	}
+	if diff := cmp.Diff(want, resp.CustomMetadata[converters.ContextManagementMetadataKey]); diff != "" {
+		t.Errorf("context management metadata mismatch (-want +got):\n%s", diff)
+	}
+
+	msg = anthropic.Message{Content: msg.Content, StopReason: msg.StopReason}
+	resp, err = converters.MessageToLLMResponse(&msg)
+	if err != nil {
+		t.Fatalf("converters.MessageToLLMResponse: %v", err)
+	}
+	if _, ok := resp.CustomMetadata[converters.ContextManagementMetadataKey]; ok {
+		t.Error("context management metadata set without applied edits")
+	}
+}
+
 func TestMessageToLLMResponse_SetsModelVersion(t *testing.T) {
 	msgJSON := `{
 		"model": "claude-sonnet-4-5-20250929",
@@ -1844,6 +2619,12 @@ func TestSalvageInterruptedMessage_TruncatedToolCall(t *testing.T) {
 	if got.PartialInput != `{"path": "/reports/summ` {
 		t.Errorf("PartialInput = %q, want the truncated fragment", got.PartialInput)
 	}
+	if diff := cmp.Diff(map[string]any{"path": "/reports/summ"}, got.RepairedArgs); diff != "" {
+		t.Errorf("RepairedArgs mismatch (-want +got):\n%s", diff)
+	}
+	if len(got.CompleteKeys) != 0 {
+		t.Errorf("CompleteKeys = %q, want none for a value cut mid-string", got.CompleteKeys)
+	}
 
 	// Salvaged parts, in stream order: thinking, text, the completed tool call.
 	// The truncated tool call must NOT appear as a part.
@@ -1867,6 +2648,93 @@ func TestSalvageInterruptedMessage_TruncatedToolCall(t *testing.T) {
 	}
 }
 
+func TestRepairPartialJSON(t *testing.T) {
+	tests := []struct {
+		name         string
+		partial      string
+		wantArgs     map[string]any
+		wantComplete []string
+	}{
+		{
+			name:         "cut_mid_string_value",
+			partial:      `{"path": "/tmp/out.txt", "content": "Hello, wor`,
+			wantArgs:     map[string]any{"path": "/tmp/out.txt", "content": "Hello, wor"},
+			wantComplete: []string{"path"},
+		},
+		{
+			name:         "cut_after_separator",
+			partial:      `{"a": 1, "b": true,`,
+			wantArgs:     map[string]any{"a": float64(1), "b": true},
+			wantComplete: []string{"a", "b"},
+		},
+		{
+			name:         "dangling_key",
+			partial:      `{"a": "x", "b":`,
+			wantArgs:     map[string]any{"a": "x"},
+			wantComplete: []string{"a"},
+		},
+		{
+			name:         "cut_mid_key",
+			partial:      `{"a": null, "bo`,
+			wantArgs:     map[string]any{"a": nil},
+			wantComplete: []string{"a"},
+		},
+		{
+			name:         "nested_containers",
+			partial:      `{"done": {"x": [1, 2]}, "items": [{"id": 1}, {"id": 2, "tags": ["a", "b`,
+			wantArgs:     map[string]any{"done": map[string]any{"x": []any{float64(1), float64(2)}}, "items": []any{map[string]any{"id": float64(1)}, map[string]any{"id": float64(2), "tags": []any{"a", "b"}}}},
+			wantComplete: []string{"done"},
+		},
+		{
+			name:     "number_at_cut_is_kept_but_not_complete",
+			partial:  `{"n": 12`,
+			wantArgs: map[string]any{"n": float64(12)},
+		},
+		{
+			name:     "partial_literal_dropped",
+			partial:  `{"ok": tru`,
+			wantArgs: map[string]any{},
+		},
+		{
+			name:     "split_escape_dropped",
+			partial:  `{"s": "line\n\u00`,
+			wantArgs: map[string]any{"s": "line\n"},
+		},
+		{
+			name:         "already_complete",
+			partial:      `{"a": "b"}`,
+			wantArgs:     map[string]any{"a": "b"},
+			wantComplete: []string{"a"},
+		},
+		{
+			name:     "empty_object_start",
+			partial:  `{`,
+			wantArgs: map[string]any{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, complete, err := converters.RepairPartialJSON(tt.partial)
+			if err != nil {
+				t.Fatalf("RepairPartialJSON() error = %v", err)
+			}
+			if diff := cmp.Diff(tt.wantArgs, args); diff != "" {
+				t.Errorf("args mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantComplete, complete); diff != "" {
+				t.Errorf("complete keys mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	for _, partial := range []string{``, `[1, 2`, `"text`, `{"a": 1 "b"`} {
+		if _, _, err := converters.RepairPartialJSON(partial); err == nil {
+			t.Errorf("RepairPartialJSON(%q) error = nil, want an error", partial)
+		}
+	}
+}
+
 func TestHasIncompleteToolInput(t *testing.T) {
 	t.Run("truncated_tool_call", func(t *testing.T) {
 		if !converters.HasIncompleteToolInput(truncatedToolMessage(t)) {
@@ -1916,9 +2784,405 @@ func TestHasIncompleteToolInput(t *testing.T) {
 		}
 	})
 
+	t.Run("non_object_tool_input", func(t *testing.T) {
+		// Non-streaming responses are decoded whole, so a cut-off input
+		// can't be invalid JSON; it shows up as a value that isn't an object.
+		const msgJSON = `{
+			"content": [{"type": "tool_use", "id": "toolu_cut", "name": "write_file", "input": "{\"path\": \"/tm"}],
+			"stop_reason": "max_tokens",
+			"usage": {"input_tokens": 5, "output_tokens": 50}
+		}`
+		var msg anthropic.Message
+		if err := msg.UnmarshalJSON([]byte(msgJSON)); err != nil {
+			t.Fatalf("failed to unmarshal message: %v", err)
+		}
+		if !converters.HasIncompleteToolInput(&msg) {
+			t.Error("HasIncompleteToolInput = false, want true for non-object tool input")
+		}
+	})
+
 	t.Run("nil_message", func(t *testing.T) {
 		if converters.HasIncompleteToolInput(nil) {
 			t.Error("HasIncompleteToolInput(nil) = true, want false")
 		}
 	})
 }
+
+type fakeGCSOpener map[string]string
+
+func (o fakeGCSOpener) OpenObject(_ context.Context, bucket, object string) (io.ReadCloser, string, error) {
+	return io.NopCloser(strings.NewReader(o[bucket+"/"+object])), "application/pdf", nil
+}
+
+type countingFetcher struct {
+	converters.BlobFetcher
+	fetches *int
+}
+
+func (f countingFetcher) Fetch(ctx context.Context, uri string) (io.ReadCloser, string, error) {
+	*f.fetches++
+	return f.BlobFetcher.Fetch(ctx, uri)
+}
+
+func TestFileDataInliner(t *testing.T) {
+	bmp := encodeTestImage(t, testImage(4, 4, false), "bmp")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/scan.bmp":
+			w.Header().Set("Content-Type", "image/bmp")
+			_, _ = w.Write(bmp)
+		case "/cat.png":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = io.WriteString(w, "png-bytes")
+		case "/untyped.jpg":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = io.WriteString(w, "jpeg-bytes")
+		case "/login":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = io.WriteString(w, "<html>sign in</html>")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	inliner := converters.FileDataInliner{
+		Fetcher: converters.SchemeBlobFetcher{
+			"http": converters.HTTPBlobFetcher{},
+			"gs":   converters.GCSBlobFetcher{Opener: fakeGCSOpener{"docs/report.pdf": "pdf-bytes"}},
+		},
+		MaxBytes: 16,
+	}
+
+	t.Run("inlines_parts_and_function_response_parts", func(t *testing.T) {
+		contents := []*genai.Content{
+			genai.NewContentFromText("unchanged", genai.RoleUser),
+			genai.NewContentFromParts([]*genai.Part{
+				genai.NewPartFromURI(srv.URL+"/cat.png", "image/png"),
+				genai.NewPartFromURI(srv.URL+"/untyped.jpg", "image/jpeg"),
+				{FileData: &genai.FileData{FileURI: "gs://docs/report.pdf", MIMEType: "application/pdf", DisplayName: "Q3 report"}},
+				{FunctionResponse: &genai.FunctionResponse{
+					ID:   "toolu_1",
+					Name: "render",
+					Parts: []*genai.FunctionResponsePart{
+						{FileData: &genai.FunctionResponseFileData{FileURI: srv.URL + "/cat.png", MIMEType: "image/png", DisplayName: "cat"}},
+					},
+				}},
+			}, genai.RoleUser),
+		}
+
+		got, err := inliner.InlineContents(t.Context(), contents)
+		if err != nil {
+			t.Fatalf("InlineContents: %v", err)
+		}
+		if got[0] != contents[0] {
+			t.Error("content without FileData was copied; want it reused")
+		}
+		parts := got[1].Parts
+		for i, want := range []genai.Blob{
+			{Data: []byte("png-bytes"), MIMEType: "image/png"},
+			{Data: []byte("jpeg-bytes"), MIMEType: "image/jpeg"},
+			{Data: []byte("pdf-bytes"), MIMEType: "application/pdf", DisplayName: "Q3 report"},
+		} {
+			if parts[i].FileData != nil || parts[i].InlineData == nil {
+				t.Fatalf("part %d = %+v, want inline data", i, parts[i])
+			}
+			if diff := cmp.Diff(want, *parts[i].InlineData); diff != "" {
+				t.Errorf("part %d mismatch (-want +got):\n%s", i, diff)
+			}
+		}
+		nested := parts[3].FunctionResponse.Parts[0]
+		if nested.InlineData == nil || string(nested.InlineData.Data) != "png-bytes" || nested.InlineData.DisplayName != "cat" {
+			t.Errorf("function response part = %+v, want inline PNG data named %q", nested, "cat")
+		}
+		if contents[1].Parts[0].FileData == nil || contents[1].Parts[3].FunctionResponse.Parts[0].FileData == nil {
+			t.Error("input contents were modified")
+		}
+
+		// The inlined parts convert to base64 sources.
+		messages, err := converters.ContentsToMessages(got)
+		if err != nil {
+			t.Fatalf("ContentsToMessages: %v", err)
+		}
+		if src := messages[0].Content[1].OfImage.Source; src.OfBase64 == nil {
+			t.Errorf("image source = %+v, want base64", src)
+		}
+	})
+
+	for _, tc := range []struct {
+		name    string
+		part    *genai.Part
+		wantErr string
+	}{
+		{"unexpected_mime_type", genai.NewPartFromURI(srv.URL+"/login", "application/pdf"), "unsupported MIME type"},
+		{"http_error", genai.NewPartFromURI(srv.URL+"/missing.png", "image/png"), "404"},
+		{"too_large", genai.NewPartFromURI("gs://docs/big.pdf", "application/pdf"), "byte limit"},
+		{"unknown_scheme", genai.NewPartFromURI("ftp://host/cat.png", "image/png"), "no fetcher"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			inliner := inliner
+			if tc.name == "too_large" {
+				inliner.Fetcher = converters.GCSBlobFetcher{Opener: fakeGCSOpener{"docs/big.pdf": strings.Repeat("x", 17)}}
+			}
+			contents := []*genai.Content{genai.NewContentFromParts([]*genai.Part{tc.part}, genai.RoleUser)}
+			_, err := inliner.InlineContents(t.Context(), contents)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("err = %v, want it to mention %q", err, tc.wantErr)
+			}
+		})
+	}
+
+	t.Run("any_image_type", func(t *testing.T) {
+		inliner := converters.FileDataInliner{Fetcher: converters.HTTPBlobFetcher{}}
+		contents := []*genai.Content{genai.NewContentFromParts([]*genai.Part{
+			genai.NewPartFromURI(srv.URL+"/scan.bmp", "image/bmp"),
+		}, genai.RoleUser)}
+		if _, err := inliner.InlineContents(t.Context(), contents); err == nil {
+			t.Error("InlineContents accepted a BMP; want the unsupported media type error")
+		}
+
+		// With AnyImageType the BMP is left for ImageNormalization to
+		// convert.
+		inliner.AnyImageType = true
+		inlined, err := inliner.InlineContents(t.Context(), contents)
+		if err != nil {
+			t.Fatalf("InlineContents: %v", err)
+		}
+		messages, _, err := converters.ContentsToMessagesWithOptions(inlined, converters.ConvertOptions{
+			ImageNormalization: &converters.ImageNormalization{},
+		})
+		if err != nil {
+			t.Fatalf("ContentsToMessagesWithOptions: %v", err)
+		}
+		if src := messages[0].Content[0].OfImage.Source.OfBase64; src == nil || src.MediaType != anthropic.Base64ImageSourceMediaTypeImagePNG {
+			t.Errorf("image source = %+v, want a base64 PNG", src)
+		}
+	})
+
+	t.Run("cache", func(t *testing.T) {
+		var fetches int
+		objects := fakeGCSOpener{"docs/a.pdf": "aaaa", "docs/b.pdf": "bbbb", "docs/c.pdf": "cccc"}
+		inliner := converters.FileDataInliner{
+			Fetcher: countingFetcher{converters.GCSBlobFetcher{Opener: objects}, &fetches},
+			Cache:   converters.NewBlobCache(8),
+		}
+		inline := func(uris ...string) error {
+			var parts []*genai.Part
+			for _, uri := range uris {
+				parts = append(parts, genai.NewPartFromURI(uri, "application/pdf"))
+			}
+			_, err := inliner.InlineContents(t.Context(), []*genai.Content{genai.NewContentFromParts(parts, genai.RoleUser)})
+			return err
+		}
+
+		for range 3 {
+			if err := inline("gs://docs/a.pdf", "gs://docs/b.pdf"); err != nil {
+				t.Fatalf("InlineContents: %v", err)
+			}
+		}
+		if fetches != 2 {
+			t.Errorf("fetches = %d, want each file fetched once", fetches)
+		}
+
+		// The cache holds two 4-byte files: c evicts the least recently
+		// used, a, while b is served from the cache once its object is
+		// gone.
+		if err := inline("gs://docs/b.pdf", "gs://docs/c.pdf"); err != nil {
+			t.Fatalf("InlineContents: %v", err)
+		}
+		delete(objects, "docs/b.pdf")
+		fetches = 0
+		if err := inline("gs://docs/b.pdf", "gs://docs/a.pdf"); err != nil {
+			t.Fatalf("InlineContents: %v", err)
+		}
+		if fetches != 1 {
+			t.Errorf("fetches = %d, want only the evicted file fetched again", fetches)
+		}
+	})
+}
+
+// testImage returns a width x height image, flat or filled with noise that
+// compresses poorly.
+func testImage(width, height int, noisy bool) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	rng := rand.New(rand.NewPCG(1, 2))
+	for y := range height {
+		for x := range width {
+			c := color.RGBA{R: 200, G: 100, B: 50, A: 255}
+			if noisy {
+				c = color.RGBA{R: uint8(rng.IntN(256)), G: uint8(rng.IntN(256)), B: uint8(rng.IntN(256)), A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func encodeTestImage(t *testing.T, img image.Image, format string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	case "bmp":
+		err = bmp.Encode(&buf, img)
+	}
+	if err != nil {
+		t.Fatalf("encode %s: %v", format, err)
+	}
+	return buf.Bytes()
+}
+
+func TestContentsToMessagesWithOptions_NormalizesImages(t *testing.T) {
+	smallJPEG := encodeTestImage(t, testImage(20, 10, false), "jpeg")
+
+	type sentImage struct {
+		MediaType     string
+		Width, Height int
+	}
+	for _, tc := range []struct {
+		name       string
+		data       []byte
+		mimeType   string
+		opts       converters.ImageNormalization
+		want       sentImage
+		wantRecord bool
+	}{
+		{"downscales_long_edge", encodeTestImage(t, testImage(3000, 1000, false), "png"), "image/png", converters.ImageNormalization{}, sentImage{"image/png", 1568, 523}, true},
+		{"downscales_megapixels", encodeTestImage(t, testImage(1000, 1000, false), "png"), "image/png", converters.ImageNormalization{MaxMegapixels: 0.25}, sentImage{"image/png", 500, 500}, true},
+		{"converts_bmp", encodeTestImage(t, testImage(40, 20, false), "bmp"), "image/bmp", converters.ImageNormalization{}, sentImage{"image/png", 40, 20}, true},
+		{"recompresses_to_byte_limit", encodeTestImage(t, testImage(300, 300, true), "png"), "image/png", converters.ImageNormalization{MaxBytes: 100_000}, sentImage{"image/jpeg", 300, 300}, true},
+		{"relabels_mismatched_type", encodeTestImage(t, testImage(20, 10, false), "png"), "image/jpeg", converters.ImageNormalization{}, sentImage{"image/png", 20, 10}, true},
+		{"leaves_fitting_image", smallJPEG, "image/jpeg", converters.ImageNormalization{}, sentImage{"image/jpeg", 20, 10}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			contents := []*genai.Content{
+				genai.NewContentFromParts([]*genai.Part{genai.NewPartFromBytes(tc.data, tc.mimeType)}, genai.RoleUser),
+			}
+			messages, report, err := converters.ContentsToMessagesWithOptions(contents, converters.ConvertOptions{ImageNormalization: &tc.opts})
+			if err != nil {
+				t.Fatalf("ContentsToMessagesWithOptions: %v", err)
+			}
+
+			src := messages[0].Content[0].OfImage.Source.OfBase64
+			data, err := base64.StdEncoding.DecodeString(src.Data)
+			if err != nil {
+				t.Fatalf("decode base64: %v", err)
+			}
+			cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("decode sent image: %v", err)
+			}
+			got := sentImage{string(src.MediaType), cfg.Width, cfg.Height}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("sent image mismatch (-want +got):\n%s", diff)
+			}
+			if tc.opts.MaxBytes > 0 && len(data) > tc.opts.MaxBytes {
+				t.Errorf("sent %d bytes, want at most %d", len(data), tc.opts.MaxBytes)
+			}
+
+			if !tc.wantRecord {
+				if len(report.NormalizedImages) != 0 || !bytes.Equal(data, tc.data) {
+					t.Errorf("image was changed (report %+v); want it sent as provided", report.NormalizedImages)
+				}
+				return
+			}
+			want := []converters.NormalizedImage{{
+				OriginalMIMEType: tc.mimeType,
+				OriginalBytes:    len(tc.data),
+				MIMEType:         got.MediaType,
+				Width:            got.Width,
+				Height:           got.Height,
+				Bytes:            len(data),
+			}}
+			// The original dimensions come from the test image itself.
+			original, _, _ := image.DecodeConfig(bytes.NewReader(tc.data))
+			want[0].OriginalWidth, want[0].OriginalHeight = original.Width, original.Height
+			if diff := cmp.Diff(want, report.NormalizedImages); diff != "" {
+				t.Errorf("report mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("function_response_parts", func(t *testing.T) {
+		bmpData := encodeTestImage(t, testImage(40, 20, false), "bmp")
+		contents := []*genai.Content{{
+			Role: genai.RoleUser,
+			Parts: []*genai.Part{{FunctionResponse: &genai.FunctionResponse{
+				ID:    "toolu_1",
+				Name:  "screenshot",
+				Parts: []*genai.FunctionResponsePart{genai.NewFunctionResponsePartFromBytes(bmpData, "image/bmp")},
+			}}},
+		}}
+		messages, report, err := converters.ContentsToMessagesWithOptions(contents, converters.ConvertOptions{
+			ImageNormalization: &converters.ImageNormalization{},
+		})
+		if err != nil {
+			t.Fatalf("ContentsToMessagesWithOptions: %v", err)
+		}
+		img := messages[0].Content[0].OfToolResult.Content[0].OfImage
+		if img == nil || img.Source.OfBase64.MediaType != anthropic.Base64ImageSourceMediaTypeImagePNG {
+			t.Errorf("tool result image = %+v, want a PNG", img)
+		}
+		if len(report.NormalizedImages) != 1 {
+			t.Errorf("report lists %d images, want 1", len(report.NormalizedImages))
+		}
+	})
+
+	t.Run("cache", func(t *testing.T) {
+		contents := []*genai.Content{genai.NewContentFromParts([]*genai.Part{
+			genai.NewPartFromBytes(encodeTestImage(t, testImage(300, 300, true), "png"), "image/png"),
+			genai.NewPartFromBytes(smallJPEG, "image/jpeg"),
+		}, genai.RoleUser)}
+		convert := func(n *converters.ImageNormalization) (string, []converters.NormalizedImage) {
+			t.Helper()
+			messages, report, err := converters.ContentsToMessagesWithOptions(contents, converters.ConvertOptions{ImageNormalization: n})
+			if err != nil {
+				t.Fatalf("ContentsToMessagesWithOptions: %v", err)
+			}
+			return messages[0].Content[0].OfImage.Source.OfBase64.Data, report.NormalizedImages
+		}
+
+		// Re-encoding must be deterministic, or every request would change
+		// the prompt-cache prefix.
+		uncached, uncachedReport := convert(&converters.ImageNormalization{MaxBytes: 100_000})
+		if again, _ := convert(&converters.ImageNormalization{MaxBytes: 100_000}); again != uncached {
+			t.Error("re-encoding the same image produced different bytes")
+		}
+
+		cache := converters.NewImageCache(converters.DefaultImageCacheBytes)
+		n := &converters.ImageNormalization{MaxBytes: 100_000, Cache: cache}
+		for i := range 2 {
+			data, report := convert(n)
+			if data != uncached {
+				t.Errorf("conversion %d sent different bytes than without a cache", i)
+			}
+			if diff := cmp.Diff(uncachedReport, report); diff != "" {
+				t.Errorf("conversion %d report mismatch (-want +got):\n%s", i, diff)
+			}
+			// Only the re-encoded image is cached; the fitting JPEG is
+			// sent as provided without decoding.
+			if got := cache.Len(); got != 1 {
+				t.Errorf("after conversion %d, cache holds %d images, want 1", i, got)
+			}
+		}
+
+		// Other limits re-encode the same image differently.
+		convert(&converters.ImageNormalization{MaxBytes: 50_000, Cache: cache})
+		if got := cache.Len(); got != 2 {
+			t.Errorf("cache holds %d images, want 2", got)
+		}
+	})
+
+	t.Run("disabled_by_default", func(t *testing.T) {
+		contents := []*genai.Content{genai.NewContentFromParts([]*genai.Part{
+			genai.NewPartFromBytes(encodeTestImage(t, testImage(4, 4, false), "bmp"), "image/bmp"),
+		}, genai.RoleUser)}
+		if _, err := converters.ContentsToMessages(contents); err == nil {
+			t.Error("ContentsToMessages accepted a BMP image; want the unsupported media type error")
+		}
+	})
+}
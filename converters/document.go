@@ -0,0 +1,101 @@
+// Copyright 2026 Alcova AI
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converters
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// TextExtractor converts documents Anthropic cannot read directly, such as
+// DOCX, XLSX or PPTX, to plain text, which is then sent as a plain-text
+// document block.
+type TextExtractor interface {
+	// ExtractText returns the text of data. It reports handled=false for
+	// MIME types it does not support, which then fail conversion as
+	// unsupported.
+	ExtractText(data []byte, mimeType string) (text string, handled bool, err error)
+}
+
+// TextExtractorFunc adapts a function to TextExtractor.
+type TextExtractorFunc func(data []byte, mimeType string) (text string, handled bool, err error)
+
+// ExtractText implements TextExtractor.
+func (f TextExtractorFunc) ExtractText(data []byte, mimeType string) (string, bool, error) {
+	return f(data, mimeType)
+}
+
+// textMIMETypes are the non-text/* MIME types sent as plain-text documents.
+var textMIMETypes = map[string]bool{
+	"application/json":       true,
+	"application/x-ndjson":   true,
+	"application/xml":        true,
+	"application/yaml":       true,
+	"application/x-yaml":     true,
+	"application/toml":       true,
+	"application/sql":        true,
+	"application/x-sh":       true,
+	"application/javascript": true,
+}
+
+// isTextMIMEType reports whether mediaType (without parameters) is text that
+// can be sent as a plain-text document.
+func isTextMIMEType(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/") || textMIMETypes[mediaType] ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") ||
+		strings.HasSuffix(mediaType, "+yaml")
+}
+
+// textDocumentBlock converts a text blob to a plain-text document block
+// titled with displayName. Anthropic accepts only UTF-8 text, so another
+// declared charset or invalid UTF-8 is an error.
+func textDocumentBlock(data []byte, mimeType, displayName string) (*anthropic.ContentBlockParamUnion, error) {
+	if _, params, err := mime.ParseMediaType(mimeType); err == nil {
+		if charset := strings.ToLower(params["charset"]); charset != "" && charset != "utf-8" && charset != "us-ascii" {
+			return nil, fmt.Errorf("unsupported charset for text document: %s", charset)
+		}
+	}
+	if !utf8.Valid(data) {
+		return nil, fmt.Errorf("text document is not valid UTF-8: %s", mimeType)
+	}
+	return plainTextDocumentBlock(string(data), displayName), nil
+}
+
+// plainTextDocumentBlock builds a plain-text document block, titled when
+// title is non-empty.
+func plainTextDocumentBlock(text, title string) *anthropic.ContentBlockParamUnion {
+	doc := &anthropic.DocumentBlockParam{
+		Source: anthropic.DocumentBlockParamSourceUnion{
+			OfText: &anthropic.PlainTextSourceParam{Data: text},
+		},
+	}
+	if title != "" {
+		doc.Title = anthropic.String(title)
+	}
+	return &anthropic.ContentBlockParamUnion{OfDocument: doc}
+}
+
+// baseMediaType returns mimeType without parameters, lowercased.
+func baseMediaType(mimeType string) string {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		mediaType, _, _ = strings.Cut(mimeType, ";")
+	}
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
@@ -0,0 +1,306 @@
+// Copyright 2026 Alcova AI
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converters
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // register the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"math"
+
+	_ "golang.org/x/image/bmp" // register the BMP decoder
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff" // register the TIFF decoder
+	_ "golang.org/x/image/webp" // register the WebP decoder
+)
+
+// Defaults for ImageNormalization, matching the sizes past which Anthropic
+// downscales images itself and its 5 MB per-image limit on base64 sources.
+const (
+	DefaultImageMaxLongEdge   = 1568
+	DefaultImageMaxMegapixels = 1.15
+	DefaultImageMaxBytes      = 5 << 20 * 3 / 4
+)
+
+// maxDecodePixels bounds the images ImageNormalization will decode, so a
+// small file declaring huge dimensions cannot exhaust memory. Anthropic
+// rejects anything above 8000x8000 anyway.
+const maxDecodePixels = 100_000_000
+
+// jpegQualities are tried in order until a JPEG fits the byte limit.
+var jpegQualities = []int{85, 70, 55, 40}
+
+// imageFormatMIMETypes maps the format names registered with the image
+// package to MIME types.
+var imageFormatMIMETypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"webp": "image/webp",
+	"bmp":  "image/bmp",
+	"tiff": "image/tiff",
+}
+
+// ImageNormalization preprocesses inline images so they fit Anthropic's
+// limits instead of failing server-side. An image is decoded only when it
+// needs work: it is larger than MaxLongEdge or MaxMegapixels, its encoded
+// size exceeds MaxBytes, or its format is one Anthropic does not accept
+// (BMP, TIFF). Such an image is downscaled to fit, re-encoded as JPEG (JPEG
+// and WebP sources) or PNG (the rest), and recompressed, falling back from
+// PNG to JPEG and to lower JPEG qualities and sizes, until it fits MaxBytes.
+// An image whose declared MIME type differs from its actual format is
+// relabelled. Images that cannot be decoded are passed through unchanged.
+//
+// Re-encoding is deterministic: the same image and limits always produce the
+// same bytes, so a normalized image in the history does not break Anthropic's
+// prompt cache. It is also the costly part, and the history is converted on
+// every request, so results are kept in Cache when one is set.
+type ImageNormalization struct {
+	// MaxLongEdge caps the longer side in pixels. Zero means
+	// DefaultImageMaxLongEdge.
+	MaxLongEdge int
+
+	// MaxMegapixels caps width times height, in millions of pixels. Zero
+	// means DefaultImageMaxMegapixels.
+	MaxMegapixels float64
+
+	// MaxBytes caps the encoded size of each image, before base64
+	// encoding. Zero means DefaultImageMaxBytes.
+	MaxBytes int
+
+	// Cache, when set, keeps re-encoded images by content hash so that each
+	// image is decoded and re-encoded once rather than on every request.
+	Cache *ImageCache
+}
+
+// DefaultImageCacheBytes is the size of the cache the model gives its
+// ImageNormalization when Cache is nil.
+const DefaultImageCacheBytes = 64 << 20
+
+// ImageCache keeps re-encoded images in memory, up to a total size, evicting
+// the least recently used first. It is safe for concurrent use and may be
+// shared between ImageNormalizations.
+type ImageCache struct {
+	lru *sizedLRU[imageCacheKey, imageCacheEntry]
+}
+
+// imageCacheKey identifies a re-encoding: the input bytes, the MIME type they
+// were declared with, and the limits they were fitted to.
+type imageCacheKey struct {
+	sum           [sha256.Size]byte
+	mimeType      string
+	width, height int
+	maxBytes      int
+}
+
+type imageCacheEntry struct {
+	data   []byte
+	record NormalizedImage
+}
+
+// NewImageCache returns a cache holding up to maxBytes of re-encoded images.
+// Images larger than maxBytes are not cached.
+func NewImageCache(maxBytes int64) *ImageCache {
+	return &ImageCache{lru: newSizedLRU[imageCacheKey, imageCacheEntry](maxBytes)}
+}
+
+// Len returns the number of cached images.
+func (c *ImageCache) Len() int {
+	return c.lru.len()
+}
+
+// get returns the cached re-encoding for key. c may be nil.
+func (c *ImageCache) get(key imageCacheKey) (imageCacheEntry, bool) {
+	if c == nil {
+		return imageCacheEntry{}, false
+	}
+	return c.lru.get(key)
+}
+
+// put caches a re-encoding. c may be nil.
+func (c *ImageCache) put(key imageCacheKey, entry imageCacheEntry) {
+	if c == nil {
+		return
+	}
+	c.lru.put(key, entry, int64(len(entry.data)))
+}
+
+// NormalizedImage records an image that ImageNormalization changed.
+type NormalizedImage struct {
+	OriginalMIMEType string `json:"original_mime_type"`
+	OriginalWidth    int    `json:"original_width"`
+	OriginalHeight   int    `json:"original_height"`
+	OriginalBytes    int    `json:"original_bytes"`
+
+	MIMEType string `json:"mime_type"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Bytes    int    `json:"bytes"`
+}
+
+// normalize returns the image to send for data, and a record when it differs
+// from the input.
+func (n *ImageNormalization) normalize(data []byte, mimeType string) ([]byte, string, *NormalizedImage, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return data, mimeType, nil, nil
+	}
+	actualMIMEType := imageFormatMIMETypes[format]
+	_, mediaTypeErr := mapImageMediaType(actualMIMEType)
+	width, height := n.fit(cfg.Width, cfg.Height)
+	maxBytes := n.maxBytes()
+
+	record := &NormalizedImage{
+		OriginalMIMEType: mimeType,
+		OriginalWidth:    cfg.Width,
+		OriginalHeight:   cfg.Height,
+		OriginalBytes:    len(data),
+	}
+	if mediaTypeErr == nil && width == cfg.Width && height == cfg.Height && len(data) <= maxBytes {
+		if actualMIMEType == mimeType {
+			return data, mimeType, nil, nil
+		}
+		record.MIMEType, record.Width, record.Height, record.Bytes = actualMIMEType, cfg.Width, cfg.Height, len(data)
+		return data, actualMIMEType, record, nil
+	}
+
+	key := imageCacheKey{
+		sum:      sha256.Sum256(data),
+		mimeType: mimeType,
+		width:    width,
+		height:   height,
+		maxBytes: maxBytes,
+	}
+	if cached, ok := n.Cache.get(key); ok {
+		*record = cached.record
+		return cached.data, record.MIMEType, record, nil
+	}
+
+	if cfg.Width*cfg.Height > maxDecodePixels {
+		return nil, "", nil, fmt.Errorf("image is too large to normalize: %dx%d", cfg.Width, cfg.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("failed to decode %s image: %w", format, err)
+	}
+
+	lossy := format == "jpeg" || format == "webp"
+	for {
+		resized := resizeImage(img, width, height)
+		out, outMIMEType, fits, err := encodeImage(resized, lossy, maxBytes)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if fits {
+			record.MIMEType, record.Width, record.Height, record.Bytes = outMIMEType, width, height, len(out)
+			n.Cache.put(key, imageCacheEntry{data: out, record: *record})
+			return out, outMIMEType, record, nil
+		}
+		if width == 1 && height == 1 {
+			return nil, "", nil, fmt.Errorf("image does not fit in %d bytes", maxBytes)
+		}
+		width, height = max(1, width*3/4), max(1, height*3/4)
+	}
+}
+
+// fit returns the largest dimensions with the aspect ratio of width x height
+// that satisfy MaxLongEdge and MaxMegapixels.
+func (n *ImageNormalization) fit(width, height int) (int, int) {
+	maxLongEdge := n.MaxLongEdge
+	if maxLongEdge <= 0 {
+		maxLongEdge = DefaultImageMaxLongEdge
+	}
+	maxMegapixels := n.MaxMegapixels
+	if maxMegapixels <= 0 {
+		maxMegapixels = DefaultImageMaxMegapixels
+	}
+
+	scale := 1.0
+	if long := max(width, height); long > maxLongEdge {
+		scale = float64(maxLongEdge) / float64(long)
+	}
+	if megapixels := float64(width) * float64(height) / 1e6; megapixels > maxMegapixels {
+		scale = min(scale, math.Sqrt(maxMegapixels/megapixels))
+	}
+	if scale >= 1 {
+		return width, height
+	}
+	scaled := func(side int) int {
+		return min(max(1, int(math.Round(float64(side)*scale))), maxLongEdge)
+	}
+	return scaled(width), scaled(height)
+}
+
+func (n *ImageNormalization) maxBytes() int {
+	if n.MaxBytes <= 0 {
+		return DefaultImageMaxBytes
+	}
+	return n.MaxBytes
+}
+
+// resizeImage scales img to width x height, or returns it as is when it
+// already has that size.
+func resizeImage(img image.Image, width, height int) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() == width && bounds.Dy() == height {
+		return img
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, xdraw.Src, nil)
+	return dst
+}
+
+// encodeImage encodes img as JPEG when lossy is set and as PNG otherwise,
+// falling back to JPEG at decreasing quality until the result fits maxBytes.
+// It reports whether the returned encoding fits.
+func encodeImage(img image.Image, lossy bool, maxBytes int) ([]byte, string, bool, error) {
+	var buf bytes.Buffer
+	if !lossy {
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, "", false, fmt.Errorf("failed to encode PNG: %w", err)
+		}
+		if buf.Len() <= maxBytes {
+			return buf.Bytes(), "image/png", true, nil
+		}
+	}
+
+	// JPEG has no alpha channel; composite onto white so transparent
+	// areas don't turn black.
+	opaque := flattenImage(img)
+	for _, quality := range jpegQualities {
+		buf.Reset()
+		if err := jpeg.Encode(&buf, opaque, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, "", false, fmt.Errorf("failed to encode JPEG: %w", err)
+		}
+		if buf.Len() <= maxBytes {
+			return buf.Bytes(), "image/jpeg", true, nil
+		}
+	}
+	return nil, "", false, nil
+}
+
+// flattenImage composites img onto a white background.
+func flattenImage(img image.Image) image.Image {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(bounds)
+	draw.Draw(dst, bounds, image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, bounds, img, bounds.Min, draw.Over)
+	return dst
+}
@@ -0,0 +1,269 @@
+// Copyright 2026 Alcova AI
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converters
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// RepairPartialJSON makes a best-effort repair of a JSON object cut off
+// mid-generation, such as the input of a tool call truncated at max_tokens.
+// An unterminated string value is closed, a dangling key, separator or
+// partial literal is dropped, and open arrays and objects are closed.
+//
+// It returns the repaired object and the top-level keys whose values were
+// complete in partial — their closing quote or bracket, or the delimiter
+// after a number or literal, was seen — so callers can trust those values
+// and treat the rest as truncated. A value cut off at the very end may be
+// shortened (a string or a number) or empty (an object or array).
+//
+// It returns an error if partial does not start a JSON object or is
+// malformed before the cut.
+func RepairPartialJSON(partial string) (map[string]any, []string, error) {
+	r := jsonRepairer{src: partial}
+	repaired, err := r.repair()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var args map[string]any
+	if err := json.Unmarshal([]byte(repaired), &args); err != nil {
+		return nil, nil, fmt.Errorf("repaired JSON is invalid: %w", err)
+	}
+	if args == nil {
+		return nil, nil, errors.New("partial JSON is not an object")
+	}
+	return args, r.completeKeys, nil
+}
+
+// jsonFrame is an open array or object.
+type jsonFrame struct {
+	object bool
+	// expectKey reports that the next string in an object is a key.
+	expectKey bool
+	// key is the object's most recent key.
+	key string
+}
+
+// jsonRepairer scans a truncated JSON document, remembering the last point at
+// which it can be cut and closed into valid JSON.
+type jsonRepairer struct {
+	src   string
+	stack []jsonFrame
+
+	// safeCut and safeClosers are the last prefix length that forms valid
+	// JSON once safeClosers is appended.
+	safeCut     int
+	safeClosers string
+	safe        bool
+
+	completeKeys []string
+}
+
+func (r *jsonRepairer) repair() (string, error) {
+	src := r.src
+	i := skipJSONSpace(src, 0)
+	if i == len(src) || src[i] != '{' {
+		return "", errors.New("partial JSON is not an object")
+	}
+
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case isJSONSpace(c):
+			i++
+
+		case c == '{' || c == '[':
+			r.stack = append(r.stack, jsonFrame{object: c == '{', expectKey: c == '{'})
+			i++
+			r.markSafe(i)
+
+		case c == '}' || c == ']':
+			if len(r.stack) == 0 || r.stack[len(r.stack)-1].object != (c == '}') {
+				return "", fmt.Errorf("unexpected %q at offset %d", c, i)
+			}
+			r.stack = r.stack[:len(r.stack)-1]
+			i++
+			if len(r.stack) == 0 {
+				return src[:i], nil
+			}
+			r.valueEnd(i, true)
+
+		case c == ':':
+			if top := r.top(); top != nil && top.object {
+				top.expectKey = false
+			}
+			i++
+
+		case c == ',':
+			if top := r.top(); top != nil && top.object {
+				top.expectKey = true
+			}
+			i++
+
+		case c == '"':
+			end, closed := scanJSONString(src, i)
+			top := r.top()
+			isKey := top != nil && top.object && top.expectKey
+			if !closed {
+				if isKey {
+					return r.cutAtSafe()
+				}
+				// Close the string value where it was cut, dropping any
+				// escape sequence the cut split.
+				return trimPartialEscape(src) + `"` + r.closers(), nil
+			}
+			if isKey {
+				var key string
+				if err := json.Unmarshal([]byte(src[i:end]), &key); err != nil {
+					return "", fmt.Errorf("invalid key at offset %d: %w", i, err)
+				}
+				top.key = key
+			} else {
+				r.valueEnd(end, true)
+			}
+			i = end
+
+		default:
+			end := i
+			for end < len(src) && isJSONLiteralByte(src[end]) {
+				end++
+			}
+			if end == i {
+				return "", fmt.Errorf("unexpected %q at offset %d", c, i)
+			}
+			token := src[i:end]
+			if end == len(src) {
+				// A literal may have been cut short: keep it only if it is
+				// already valid, and don't vouch for a number's digits.
+				if !json.Valid([]byte(token)) {
+					return r.cutAtSafe()
+				}
+				_, isLiteral := jsonLiterals[token]
+				r.valueEnd(end, isLiteral)
+				return r.cutAtSafe()
+			}
+			if !json.Valid([]byte(token)) {
+				return "", fmt.Errorf("invalid literal %q at offset %d", token, i)
+			}
+			r.valueEnd(end, true)
+			i = end
+		}
+	}
+	return r.cutAtSafe()
+}
+
+var jsonLiterals = map[string]struct{}{"true": {}, "false": {}, "null": {}}
+
+func (r *jsonRepairer) top() *jsonFrame {
+	if len(r.stack) == 0 {
+		return nil
+	}
+	return &r.stack[len(r.stack)-1]
+}
+
+// valueEnd records that a value ended at offset end. complete reports whether
+// the value is known not to have been cut short.
+func (r *jsonRepairer) valueEnd(end int, complete bool) {
+	if complete && len(r.stack) == 1 && r.stack[0].object {
+		r.completeKeys = append(r.completeKeys, r.stack[0].key)
+	}
+	r.markSafe(end)
+}
+
+func (r *jsonRepairer) markSafe(cut int) {
+	r.safeCut, r.safeClosers, r.safe = cut, r.closers(), true
+}
+
+func (r *jsonRepairer) cutAtSafe() (string, error) {
+	if !r.safe {
+		return "", errors.New("no repairable prefix")
+	}
+	return r.src[:r.safeCut] + r.safeClosers, nil
+}
+
+// closers returns the brackets that close every open frame, innermost first.
+func (r *jsonRepairer) closers() string {
+	var b strings.Builder
+	for i := len(r.stack) - 1; i >= 0; i-- {
+		if r.stack[i].object {
+			b.WriteByte('}')
+		} else {
+			b.WriteByte(']')
+		}
+	}
+	return b.String()
+}
+
+// scanJSONString returns the offset just past the string starting at
+// src[start] (a quote), and whether its closing quote was found.
+func scanJSONString(src string, start int) (int, bool) {
+	for i := start + 1; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1, true
+		}
+	}
+	return len(src), false
+}
+
+// trimPartialEscape drops an escape sequence split by the cut from the end of
+// an unterminated string: a lone backslash or an incomplete \uXXXX.
+func trimPartialEscape(s string) string {
+	// Find the start of the last escape sequence, if any.
+	for i := len(s) - 1; i >= 0 && i >= len(s)-6; i-- {
+		if s[i] != '\\' {
+			continue
+		}
+		// Count the run of backslashes ending at i: an even-length run
+		// is made of escaped backslashes and starts no sequence.
+		run := 0
+		for j := i; j >= 0 && s[j] == '\\'; j-- {
+			run++
+		}
+		if run%2 == 0 {
+			return s
+		}
+		tail := s[i+1:]
+		switch {
+		case tail == "":
+			return s[:i]
+		case tail[0] == 'u' && len(tail) < 5:
+			return s[:i]
+		}
+		return s
+	}
+	return s
+}
+
+func skipJSONSpace(s string, i int) int {
+	for i < len(s) && isJSONSpace(s[i]) {
+		i++
+	}
+	return i
+}
+
+func isJSONSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isJSONLiteralByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '+' || c == '.' || c == 'E'
+}
@@ -0,0 +1,87 @@
+// Copyright 2026 Alcova AI
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converters
+
+import (
+	"container/list"
+	"sync"
+)
+
+// sizedLRU holds values up to a total size, evicting the least recently used
+// first. It is safe for concurrent use.
+type sizedLRU[K comparable, V any] struct {
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List // of *sizedLRUEntry, most recently used first
+	entries map[K]*list.Element
+}
+
+type sizedLRUEntry[K comparable, V any] struct {
+	key   K
+	value V
+	size  int64
+}
+
+func newSizedLRU[K comparable, V any](maxBytes int64) *sizedLRU[K, V] {
+	return &sizedLRU[K, V]{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[K]*list.Element),
+	}
+}
+
+func (c *sizedLRU[K, V]) get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*sizedLRUEntry[K, V]).value, true
+}
+
+// put stores value, evicting older values to make room. Values larger than
+// maxBytes are not stored.
+func (c *sizedLRU[K, V]) put(key K, value V, size int64) {
+	if size > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.size -= elem.Value.(*sizedLRUEntry[K, V]).size
+		c.order.Remove(elem)
+		delete(c.entries, key)
+	}
+	for c.size+size > c.maxBytes {
+		oldest := c.order.Back()
+		entry := oldest.Value.(*sizedLRUEntry[K, V])
+		c.order.Remove(oldest)
+		delete(c.entries, entry.key)
+		c.size -= entry.size
+	}
+	c.entries[key] = c.order.PushFront(&sizedLRUEntry[K, V]{key: key, value: value, size: size})
+	c.size += size
+}
+
+func (c *sizedLRU[K, V]) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
@@ -25,80 +25,149 @@ import (
 	"google.golang.org/genai"
 )
 
-const (
-	continuationPrompt              = "Continue processing previous requests as instructed. Exit or provide a summary if no more outputs are needed."
-	redactedThinkingDataMetadataKey = "anthropic.redacted_thinking_data"
-)
+const redactedThinkingDataMetadataKey = "anthropic.redacted_thinking_data"
+
+// DefaultContinuationPrompt is the text of the user message inserted between
+// model turns that cannot be merged without modifying a thinking block.
+const DefaultContinuationPrompt = "Continue processing previous requests as instructed. Exit or provide a summary if no more outputs are needed."
+
+// CitationsEnabledMetadataKey, set to true in a Part's PartMetadata, enables
+// citations on the document block the part converts to. Set to false, it
+// disables them for that part even when ConvertOptions.Citations is set.
+const CitationsEnabledMetadataKey = "anthropic.citations_enabled"
+
+// ConvertOptions configures ContentsToMessagesWithOptions. The zero value
+// converts exactly as ContentsToMessages does.
+type ConvertOptions struct {
+	// ImageNormalization, when set, downscales, converts and recompresses
+	// inline images to fit Anthropic's limits. See ImageNormalization.
+	ImageNormalization *ImageNormalization
+
+	// TextExtractor, when set, converts inline documents in formats that
+	// are neither images, PDF nor text (e.g. office formats) to plain-text
+	// document blocks.
+	TextExtractor TextExtractor
+
+	// Citations enables citations on every document block (PDF, text and
+	// extracted documents, including those in tool results), so the
+	// response cites the passages it used. A part's
+	// CitationsEnabledMetadataKey overrides it for that part.
+	Citations bool
+
+	// ToolErrorDetector decides which function responses are sent as
+	// failed tool results (is_error). Nil means DefaultToolErrorDetector.
+	ToolErrorDetector ToolErrorDetector
+
+	// ToolResultUnwrapping, when set, sends textual tool results as raw
+	// text blocks instead of JSON. See ToolResultUnwrapping.
+	ToolResultUnwrapping *ToolResultUnwrapping
+
+	// HistorySanitizer, when set, repairs unpaired tool calls and tool
+	// results instead of letting Anthropic reject the request. See
+	// HistorySanitizer.
+	HistorySanitizer *HistorySanitizer
+
+	// StrictRoles accepts only the user, model and assistant roles, failing
+	// conversion on any other. By default, system-role contents are
+	// hoisted into ConvertReport.System, tool and function roles are
+	// treated as user, and an empty role defaults to user, as found in
+	// histories imported from other frameworks.
+	StrictRoles bool
+
+	// ContinuationPrompt is the text of the user message inserted between
+	// model turns that cannot be merged because of thinking blocks. Empty
+	// means DefaultContinuationPrompt.
+	ContinuationPrompt string
+}
+
+// ConvertReport describes what ContentsToMessagesWithOptions changed on the
+// way, for debugging.
+type ConvertReport struct {
+	// NormalizedImages lists the images ImageNormalization changed, in
+	// conversation order.
+	NormalizedImages []NormalizedImage
+
+	// System holds the text of system-role contents, in order, for the
+	// caller to append to the system prompt.
+	System []anthropic.TextBlockParam
+}
+
+// conversion holds the options and report of a single conversion.
+type conversion struct {
+	opts   ConvertOptions
+	report ConvertReport
+}
 
 // ContentsToMessages converts genai Contents to Anthropic MessageParams. It
 // merges ordinary same-role turns, but inserts a synthetic user continuation
 // between assistant turns when merging would modify a thinking block. It
 // returns an error when an unresolved tool use prevents inserting that boundary.
+//
+// ContentsToMessages cannot return the system prompt, so system-role contents
+// are an error; ContentsToMessagesWithOptions hoists them.
 func ContentsToMessages(contents []*genai.Content) ([]anthropic.MessageParam, error) {
+	messages, report, err := ContentsToMessagesWithOptions(contents, ConvertOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if len(report.System) > 0 {
+		return nil, fmt.Errorf("failed to convert content: system-role contents require ContentsToMessagesWithOptions")
+	}
+	return messages, nil
+}
+
+// ContentsToMessagesWithOptions is ContentsToMessages with optional
+// preprocessing. It also reports what the preprocessing changed.
+func ContentsToMessagesWithOptions(contents []*genai.Content, opts ConvertOptions) ([]anthropic.MessageParam, *ConvertReport, error) {
+	c := &conversion{opts: opts}
 	if len(contents) == 0 {
-		return nil, nil
+		return nil, &c.report, nil
 	}
 
 	var messages []anthropic.MessageParam
-	for _, content := range contents {
+	var sources []int // index in contents of each message
+	for i, content := range contents {
 		if content == nil {
 			continue
 		}
 
-		msg, err := contentToMessage(content)
+		msg, err := c.contentToMessage(content)
 		if err != nil {
-			return nil, fmt.Errorf("failed to convert content: %w", err)
+			return nil, nil, fmt.Errorf("failed to convert content: %w", err)
 		}
 		if msg != nil {
 			messages = append(messages, *msg)
+			sources = append(sources, i)
 		}
 	}
 
+	if opts.HistorySanitizer != nil {
+		messages = opts.HistorySanitizer.repair(messages, sources)
+	}
+
 	// Anthropic combines consecutive messages with the same role. Normalise them
 	// explicitly so signed thinking blocks retain their original message boundary.
-	messages, err := normalizeConsecutiveMessages(messages)
+	messages, err := normalizeConsecutiveMessages(messages, opts.ContinuationPrompt)
 	if err != nil {
-		return nil, fmt.Errorf("failed to normalize messages: %w", err)
+		return nil, nil, fmt.Errorf("failed to normalize messages: %w", err)
 	}
 
-	return messages, nil
+	return messages, &c.report, nil
 }
 
 // contentToMessage converts a single genai.Content to an Anthropic MessageParam.
-func contentToMessage(content *genai.Content) (*anthropic.MessageParam, error) {
+func (c *conversion) contentToMessage(content *genai.Content) (*anthropic.MessageParam, error) {
 	if content == nil || len(content.Parts) == 0 {
 		return nil, nil
 	}
 
-	// Check if this content contains tool results (FunctionResponse).
-	// Anthropic requires tool results to be in user messages.
-	hasFunctionResponse := false
-	hasFunctionCall := false
-	for _, part := range content.Parts {
-		if part != nil {
-			if part.FunctionResponse != nil {
-				hasFunctionResponse = true
-			}
-			if part.FunctionCall != nil {
-				hasFunctionCall = true
-			}
-		}
+	if !c.opts.StrictRoles && strings.EqualFold(content.Role, "system") {
+		return nil, c.hoistSystem(content)
 	}
 
-	// Determine the role - tool results must be user, tool calls must be assistant
-	var role anthropic.MessageParamRole
-	if hasFunctionResponse {
-		// Tool results MUST be in user messages per Anthropic API requirements
-		role = anthropic.MessageParamRoleUser
-	} else if hasFunctionCall {
-		// Tool calls (from model) MUST be in assistant messages
-		role = anthropic.MessageParamRoleAssistant
-	} else {
-		var err error
-		role, err = mapRole(content.Role)
-		if err != nil {
-			return nil, err
-		}
+	role, err := messageRole(content, c.opts.StrictRoles)
+	if err != nil {
+		return nil, err
 	}
 
 	var blocks []anthropic.ContentBlockParamUnion
@@ -113,7 +182,7 @@ func contentToMessage(content *genai.Content) (*anthropic.MessageParam, error) {
 		if role == anthropic.MessageParamRoleUser && part.Thought {
 			continue
 		}
-		block, err := PartToContentBlock(part)
+		block, err := c.partToContentBlock(part)
 		if err != nil {
 			return nil, fmt.Errorf("failed to convert part: %w", err)
 		}
@@ -133,20 +202,90 @@ func contentToMessage(content *genai.Content) (*anthropic.MessageParam, error) {
 	return &msg, nil
 }
 
-// mapRole maps genai role to Anthropic MessageParamRole.
-func mapRole(role string) (anthropic.MessageParamRole, error) {
+// MessageRole returns the role of the message content converts to, or ""
+// when it converts to none: nil and empty contents, and, unless strictRoles
+// is set, system-role contents, whose text joins the system prompt. It
+// applies the same role rules as ContentsToMessagesWithOptions with
+// ConvertOptions.StrictRoles set to strictRoles.
+func MessageRole(content *genai.Content, strictRoles bool) (anthropic.MessageParamRole, error) {
+	if content == nil || len(content.Parts) == 0 {
+		return "", nil
+	}
+	if !strictRoles && strings.EqualFold(content.Role, "system") {
+		return "", nil
+	}
+	return messageRole(content, strictRoles)
+}
+
+// messageRole determines the role of a non-system content.
+func messageRole(content *genai.Content, strict bool) (anthropic.MessageParamRole, error) {
+	// Check if this content contains tool results (FunctionResponse).
+	// Anthropic requires tool results to be in user messages.
+	hasFunctionResponse := false
+	hasFunctionCall := false
+	for _, part := range content.Parts {
+		if part != nil {
+			if part.FunctionResponse != nil {
+				hasFunctionResponse = true
+			}
+			if part.FunctionCall != nil {
+				hasFunctionCall = true
+			}
+		}
+	}
+
+	// Determine the role - tool results must be user, tool calls must be assistant
+	if hasFunctionResponse {
+		// Tool results MUST be in user messages per Anthropic API requirements
+		return anthropic.MessageParamRoleUser, nil
+	}
+	if hasFunctionCall {
+		// Tool calls (from model) MUST be in assistant messages
+		return anthropic.MessageParamRoleAssistant, nil
+	}
+	return mapRole(content.Role, strict)
+}
+
+// mapRole maps genai role to Anthropic MessageParamRole. Unless strict, tool
+// and function roles, which carry tool results in other frameworks, and the
+// empty role map to user.
+func mapRole(role string, strict bool) (anthropic.MessageParamRole, error) {
 	switch strings.ToLower(role) {
 	case "user":
 		return anthropic.MessageParamRoleUser, nil
 	case "model", "assistant":
 		return anthropic.MessageParamRoleAssistant, nil
-	default:
-		return "", fmt.Errorf("unsupported role: %s", role)
+	case "tool", "function", "":
+		if !strict {
+			return anthropic.MessageParamRoleUser, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported role: %s", role)
+}
+
+// hoistSystem appends the text of a system-role content to the report. The
+// system prompt holds only text, so other parts are an error; thoughts are
+// skipped.
+func (c *conversion) hoistSystem(content *genai.Content) error {
+	for _, part := range content.Parts {
+		switch {
+		case part == nil || part.Thought:
+		case part.Text != "":
+			c.report.System = append(c.report.System, anthropic.TextBlockParam{Text: part.Text})
+		case part.InlineData != nil, part.FileData != nil, part.FunctionCall != nil, part.FunctionResponse != nil,
+			part.ExecutableCode != nil, part.CodeExecutionResult != nil:
+			return fmt.Errorf("system-role content supports only text parts")
+		}
 	}
+	return nil
 }
 
 // PartToContentBlock converts a genai Part to an Anthropic ContentBlockParamUnion.
 func PartToContentBlock(part *genai.Part) (*anthropic.ContentBlockParamUnion, error) {
+	return (&conversion{}).partToContentBlock(part)
+}
+
+func (c *conversion) partToContentBlock(part *genai.Part) (*anthropic.ContentBlockParamUnion, error) {
 	if part == nil {
 		return nil, nil
 	}
@@ -174,17 +313,21 @@ func PartToContentBlock(part *genai.Part) (*anthropic.ContentBlockParamUnion, er
 
 	// Inline binary data (images, PDFs)
 	if part.InlineData != nil {
-		return inlineDataToBlock(part.InlineData)
+		block, err := c.inlineDataToBlock(part.InlineData)
+		c.enableCitations(block, part)
+		return block, err
 	}
 
 	// File data (URI-based)
 	if part.FileData != nil {
-		return fileDataToBlock(part.FileData)
+		block, err := fileDataToBlock(part.FileData)
+		c.enableCitations(block, part)
+		return block, err
 	}
 
 	// Function response (tool result)
 	if part.FunctionResponse != nil {
-		return functionResponseToBlock(part.FunctionResponse)
+		return c.functionResponseToBlock(part.FunctionResponse)
 	}
 
 	// Function call - these should only appear in model responses, not requests
@@ -202,6 +345,24 @@ func PartToContentBlock(part *genai.Part) (*anthropic.ContentBlockParamUnion, er
 	return nil, nil
 }
 
+// enableCitations turns on citations for a document block when part asks for
+// them, or when the conversion enables them for every document. part is nil
+// for function response parts, which have no metadata of their own.
+func (c *conversion) enableCitations(block *anthropic.ContentBlockParamUnion, part *genai.Part) {
+	if block == nil || block.OfDocument == nil {
+		return
+	}
+	enabled := c.opts.Citations
+	if part != nil {
+		if v, ok := part.PartMetadata[CitationsEnabledMetadataKey].(bool); ok {
+			enabled = v
+		}
+	}
+	if enabled {
+		block.OfDocument.Citations = anthropic.CitationsConfigParam{Enabled: anthropic.Bool(true)}
+	}
+}
+
 func redactedThinkingData(part *genai.Part) (string, bool) {
 	if !part.Thought || part.PartMetadata == nil {
 		return "", false
@@ -210,8 +371,10 @@ func redactedThinkingData(part *genai.Part) (string, bool) {
 	return data, ok
 }
 
-// inlineDataToBlock converts inline binary data to an Anthropic content block.
-func inlineDataToBlock(blob *genai.Blob) (*anthropic.ContentBlockParamUnion, error) {
+// inlineDataToBlock converts inline binary data to an Anthropic content block:
+// images and PDFs as base64 sources, and text and extracted documents as
+// plain-text documents titled with the blob's display name.
+func (c *conversion) inlineDataToBlock(blob *genai.Blob) (*anthropic.ContentBlockParamUnion, error) {
 	if blob == nil {
 		return nil, nil
 	}
@@ -220,6 +383,17 @@ func inlineDataToBlock(blob *genai.Blob) (*anthropic.ContentBlockParamUnion, err
 
 	// Handle images
 	if strings.HasPrefix(mimeType, "image/") {
+		data := blob.Data
+		if n := c.opts.ImageNormalization; n != nil {
+			normalized, normalizedMIMEType, record, err := n.normalize(data, mimeType)
+			if err != nil {
+				return nil, fmt.Errorf("failed to normalize image: %w", err)
+			}
+			if record != nil {
+				data, mimeType = normalized, normalizedMIMEType
+				c.report.NormalizedImages = append(c.report.NormalizedImages, *record)
+			}
+		}
 		mediaType, err := mapImageMediaType(mimeType)
 		if err != nil {
 			return nil, err
@@ -228,7 +402,7 @@ func inlineDataToBlock(blob *genai.Blob) (*anthropic.ContentBlockParamUnion, err
 			OfImage: &anthropic.ImageBlockParam{
 				Source: anthropic.ImageBlockParamSourceUnion{
 					OfBase64: &anthropic.Base64ImageSourceParam{
-						Data:      base64.StdEncoding.EncodeToString(blob.Data),
+						Data:      base64.StdEncoding.EncodeToString(data),
 						MediaType: mediaType,
 					},
 				},
@@ -251,6 +425,22 @@ func inlineDataToBlock(blob *genai.Blob) (*anthropic.ContentBlockParamUnion, err
 		return &block, nil
 	}
 
+	// Text-like data (plain text, Markdown, CSV, JSON, ...)
+	if mediaType := baseMediaType(mimeType); isTextMIMEType(mediaType) {
+		return textDocumentBlock(blob.Data, mimeType, blob.DisplayName)
+	}
+
+	// Other documents, if the extractor can read them
+	if c.opts.TextExtractor != nil {
+		text, handled, err := c.opts.TextExtractor.ExtractText(blob.Data, mimeType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract text from %s: %w", mimeType, err)
+		}
+		if handled {
+			return plainTextDocumentBlock(text, blob.DisplayName), nil
+		}
+	}
+
 	return nil, fmt.Errorf("unsupported MIME type for inline data: %s", mimeType)
 }
 
@@ -310,7 +500,7 @@ func fileDataToBlock(fileData *genai.FileData) (*anthropic.ContentBlockParamUnio
 }
 
 // functionResponseToBlock converts a FunctionResponse to an Anthropic tool result block.
-func functionResponseToBlock(resp *genai.FunctionResponse) (*anthropic.ContentBlockParamUnion, error) {
+func (c *conversion) functionResponseToBlock(resp *genai.FunctionResponse) (*anthropic.ContentBlockParamUnion, error) {
 	if resp == nil {
 		return nil, nil
 	}
@@ -321,9 +511,18 @@ func functionResponseToBlock(resp *genai.FunctionResponse) (*anthropic.ContentBl
 		return nil, fmt.Errorf("FunctionResponse.ID is required for tool call correlation (function: %s)", resp.Name)
 	}
 
+	// Search results become search_result blocks the model can cite, and
+	// unwrapped strings raw text blocks; the rest of the response is sent
+	// as JSON text ahead of them.
+	searchResults, response, err := splitSearchResults(resp.Response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert search results (function: %s): %w", resp.Name, err)
+	}
+	texts, response := c.opts.ToolResultUnwrapping.unwrap(response)
+
 	var content []anthropic.ToolResultBlockParamContentUnion
-	if resp.Response != nil {
-		jsonBytes, err := json.Marshal(resp.Response)
+	if response != nil {
+		jsonBytes, err := json.Marshal(response)
 		if err != nil {
 			return nil, fmt.Errorf("failed to marshal function response: %w", err)
 		}
@@ -331,9 +530,15 @@ func functionResponseToBlock(resp *genai.FunctionResponse) (*anthropic.ContentBl
 			OfText: &anthropic.TextBlockParam{Text: string(jsonBytes)},
 		})
 	}
+	for _, text := range texts {
+		content = append(content, anthropic.ToolResultBlockParamContentUnion{
+			OfText: &anthropic.TextBlockParam{Text: text},
+		})
+	}
+	content = append(content, searchResults...)
 
 	for _, part := range resp.Parts {
-		converted, err := functionResponsePartToBlock(part)
+		converted, err := c.functionResponsePartToBlock(part)
 		if err != nil {
 			return nil, fmt.Errorf("failed to convert function response part: %w", err)
 		}
@@ -346,13 +551,13 @@ func functionResponseToBlock(resp *genai.FunctionResponse) (*anthropic.ContentBl
 		OfToolResult: &anthropic.ToolResultBlockParam{
 			ToolUseID: resp.ID,
 			Content:   content,
-			IsError:   anthropic.Bool(false),
+			IsError:   anthropic.Bool(c.isToolError(resp)),
 		},
 	}
 	return &block, nil
 }
 
-func functionResponsePartToBlock(part *genai.FunctionResponsePart) (*anthropic.ToolResultBlockParamContentUnion, error) {
+func (c *conversion) functionResponsePartToBlock(part *genai.FunctionResponsePart) (*anthropic.ToolResultBlockParamContentUnion, error) {
 	if part == nil {
 		return nil, nil
 	}
@@ -361,9 +566,13 @@ func functionResponsePartToBlock(part *genai.FunctionResponsePart) (*anthropic.T
 	var err error
 	switch {
 	case part.InlineData != nil:
-		block, err = inlineDataToBlock(&genai.Blob{
-			Data:     part.InlineData.Data,
-			MIMEType: part.InlineData.MIMEType,
+		if text, ok := c.opts.ToolResultUnwrapping.partText(part.InlineData); ok {
+			return text, nil
+		}
+		block, err = c.inlineDataToBlock(&genai.Blob{
+			Data:        part.InlineData.Data,
+			MIMEType:    part.InlineData.MIMEType,
+			DisplayName: part.InlineData.DisplayName,
 		})
 	case part.FileData != nil:
 		block, err = fileDataToBlock(&genai.FileData{
@@ -380,6 +589,7 @@ func functionResponsePartToBlock(part *genai.FunctionResponsePart) (*anthropic.T
 	if block == nil {
 		return nil, nil
 	}
+	c.enableCitations(block, nil)
 
 	switch {
 	case block.OfImage != nil:
@@ -429,11 +639,15 @@ func SystemInstructionToSystem(instruction *genai.Content) []anthropic.TextBlock
 // normalizeConsecutiveMessages makes roles alternate without modifying
 // thinking-bearing messages. Anthropic rejects signed thinking blocks when
 // their original assistant message is changed, including by combining it with
-// an adjacent assistant message.
-func normalizeConsecutiveMessages(messages []anthropic.MessageParam) ([]anthropic.MessageParam, error) {
+// an adjacent assistant message. prompt is the text of the user continuation
+// inserted at such a boundary.
+func normalizeConsecutiveMessages(messages []anthropic.MessageParam, prompt string) ([]anthropic.MessageParam, error) {
 	if len(messages) <= 1 {
 		return messages, nil
 	}
+	if prompt == "" {
+		prompt = DefaultContinuationPrompt
+	}
 
 	var normalized []anthropic.MessageParam
 	for i, msg := range messages {
@@ -454,7 +668,7 @@ func normalizeConsecutiveMessages(messages []anthropic.MessageParam) ([]anthropi
 				return nil, fmt.Errorf("cannot preserve thinking boundary after tool use without an intervening tool result")
 			}
 			normalized = append(normalized,
-				anthropic.NewUserMessage(anthropic.NewTextBlock(continuationPrompt)),
+				anthropic.NewUserMessage(anthropic.NewTextBlock(prompt)),
 				msg,
 			)
 		} else {
@@ -20,11 +20,106 @@ import (
 	"fmt"
 
 	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/packages/respjson"
 	"google.golang.org/genai"
 
 	"google.golang.org/adk/v2/model"
 )
 
+// LLMResponse.CustomMetadata keys set by this package. All are scoped under
+// "anthropic." so they don't collide with other providers' metadata.
+const (
+	// MessageIDMetadataKey carries the Anthropic message id (msg_...).
+	MessageIDMetadataKey = "anthropic.message_id"
+
+	// StopReasonMetadataKey carries the raw Anthropic stop reason, so
+	// callers can branch on values that share a genai FinishReason (e.g.
+	// end_turn vs tool_use) or have none of their own (pause_turn).
+	StopReasonMetadataKey = "anthropic.stop_reason"
+
+	// RefusalCategoryMetadataKey and RefusalExplanationMetadataKey carry
+	// the stop details of a refusal, when Anthropic provides them.
+	RefusalCategoryMetadataKey    = "anthropic.refusal_category"
+	RefusalExplanationMetadataKey = "anthropic.refusal_explanation"
+
+	// StopSequenceMetadataKey carries the custom stop sequence that ended
+	// generation, when stop_reason is "stop_sequence".
+	StopSequenceMetadataKey = "anthropic.stop_sequence"
+
+	// BlockIndexMetadataKey carries the index (int64) of the content block
+	// a streamed partial belongs to. Set on text and thinking deltas and on
+	// block start/stop markers.
+	BlockIndexMetadataKey = "anthropic.block_index"
+
+	// BlockEventMetadataKey marks a content-free partial as the start
+	// (BlockEventStart) or end (BlockEventStop) of the content block at
+	// BlockIndexMetadataKey, whose type BlockTypeMetadataKey carries
+	// ("text", "thinking", "tool_use", ...).
+	BlockEventMetadataKey = "anthropic.block_event"
+	BlockTypeMetadataKey  = "anthropic.block_type"
+
+	// ThinkingSignatureMetadataKey carries the signature of a thinking
+	// block, as sent by Anthropic, on that block's stop marker.
+	ThinkingSignatureMetadataKey = "anthropic.thinking_signature"
+
+	// CitationsMetadataKey carries a []CitationDetail with the Anthropic
+	// details of each entry of CitationMetadata.Citations, in the same
+	// order: the cited text, the cited document and the text part citing
+	// it. Absent when the response has no citations.
+	CitationsMetadataKey = "anthropic.citations"
+
+	// The keys below describe the HTTP exchange rather than the message, so
+	// this package never sets them; the model sets them on final responses.
+
+	// RequestIDMetadataKey carries the request-id response header, the
+	// identifier Anthropic support asks for.
+	RequestIDMetadataKey = "anthropic.request_id"
+
+	// VariantMetadataKey carries the backend that served the request
+	// ("ANTHROPIC_API" or "VERTEX_AI").
+	VariantMetadataKey = "anthropic.variant"
+
+	// RateLimitMetadataKey carries the anthropic-ratelimit-*-remaining and
+	// -reset response headers as a map[string]string keyed by the header
+	// name without its "anthropic-ratelimit-" prefix (e.g.
+	// "tokens-remaining"). Absent when the response carried none.
+	RateLimitMetadataKey = "anthropic.rate_limit"
+
+	// NormalizedImagesMetadataKey carries the []NormalizedImage that
+	// ImageNormalization changed in the request. Absent when it changed
+	// none.
+	NormalizedImagesMetadataKey = "anthropic.normalized_images"
+
+	// TrimmedHistoryMetadataKey carries the *TrimmedHistory describing the
+	// contents a HistoryTrimmer dropped from the request. Absent when it
+	// dropped none.
+	TrimmedHistoryMetadataKey = "anthropic.trimmed_history"
+
+	// ContextManagementMetadataKey carries the []AppliedContextEdit that
+	// Anthropic's context management applied to the request, and to any
+	// continuation requests after it, in order. Absent when it applied
+	// none.
+	ContextManagementMetadataKey = "anthropic.context_management"
+)
+
+// Values of BlockEventMetadataKey.
+const (
+	BlockEventStart = "start"
+	BlockEventStop  = "stop"
+)
+
+// StopReasonModelContextWindowExceeded is the stop reason Anthropic reports
+// when generation stopped because the context window filled up, rather than
+// at the requested max_tokens. The SDK does not define a constant for it yet.
+const StopReasonModelContextWindowExceeded anthropic.StopReason = "model_context_window_exceeded"
+
+// FinishReasonContextWindowExceeded is the FinishReason mapped from
+// StopReasonModelContextWindowExceeded. genai has no equivalent, and mapping
+// it to FinishReasonMaxTokens would invite callers to retry with a larger
+// max_tokens, which cannot help; this distinct value lets them trim history
+// instead.
+const FinishReasonContextWindowExceeded genai.FinishReason = "MODEL_CONTEXT_WINDOW_EXCEEDED"
+
 // MessageToLLMResponse converts an Anthropic Message to a model.LLMResponse.
 func MessageToLLMResponse(msg *anthropic.Message) (*model.LLMResponse, error) {
 	if msg == nil {
@@ -37,6 +132,7 @@ func MessageToLLMResponse(msg *anthropic.Message) (*model.LLMResponse, error) {
 	}
 
 	var allCitations []*genai.Citation
+	var citationDetails []CitationDetail
 	for _, block := range msg.Content {
 		part, err := ContentBlockToGenaiPart(block)
 		if err != nil {
@@ -46,9 +142,11 @@ func MessageToLLMResponse(msg *anthropic.Message) (*model.LLMResponse, error) {
 			content.Parts = append(content.Parts, part)
 		}
 		// Collect citations from text blocks
-		if textBlock, ok := block.AsAny().(anthropic.TextBlock); ok {
-			if citations := textCitationsToSlice(textBlock.Citations); len(citations) > 0 {
-				allCitations = append(allCitations, citations...)
+		if textBlock, ok := block.AsAny().(anthropic.TextBlock); ok && part != nil {
+			partIndex := len(content.Parts) - 1
+			for _, c := range textBlock.Citations {
+				allCitations = append(allCitations, textCitationToGenai(c))
+				citationDetails = append(citationDetails, textCitationDetail(c, partIndex))
 			}
 		}
 	}
@@ -62,11 +160,86 @@ func MessageToLLMResponse(msg *anthropic.Message) (*model.LLMResponse, error) {
 
 	if len(allCitations) > 0 {
 		resp.CitationMetadata = &genai.CitationMetadata{Citations: allCitations}
+		setMetadata(resp, CitationsMetadataKey, citationDetails)
+	}
+
+	if msg.ID != "" {
+		setMetadata(resp, MessageIDMetadataKey, msg.ID)
+	}
+	addStopMetadata(resp, msg)
+	if edits := appliedContextEdits(msg); len(edits) > 0 {
+		setMetadata(resp, ContextManagementMetadataKey, edits)
 	}
 
 	return resp, nil
 }
 
+// AppliedContextEdit describes a context management edit Anthropic applied to
+// the request before the model saw it.
+type AppliedContextEdit struct {
+	// Type is the edit type: "clear_tool_uses_20250919" or
+	// "clear_thinking_20251015".
+	Type string `json:"type"`
+
+	// ClearedInputTokens is the number of input tokens the edit removed.
+	ClearedInputTokens int64 `json:"cleared_input_tokens"`
+
+	// ClearedToolUses and ClearedThinkingTurns count what the edit
+	// cleared; only the one matching Type is set.
+	ClearedToolUses      int64 `json:"cleared_tool_uses,omitempty"`
+	ClearedThinkingTurns int64 `json:"cleared_thinking_turns,omitempty"`
+}
+
+// ContextManagementField is the JSON field carrying context management: the
+// edits to apply in a request, and the edits applied in a response. The
+// SDK's message types predate it, so it travels as an extra field.
+const ContextManagementField = "context_management"
+
+// appliedContextEdits returns the edits reported in msg's context management
+// field, read from the raw JSON.
+func appliedContextEdits(msg *anthropic.Message) []AppliedContextEdit {
+	raw := msg.JSON.ExtraFields[ContextManagementField].Raw()
+	if raw == "" || raw == respjson.Null {
+		return nil
+	}
+	var cm struct {
+		AppliedEdits []AppliedContextEdit `json:"applied_edits"`
+	}
+	if err := json.Unmarshal([]byte(raw), &cm); err != nil {
+		return nil
+	}
+	return cm.AppliedEdits
+}
+
+// addStopMetadata records the raw stop reason, the matched stop sequence and
+// a refusal's stop details in the response's CustomMetadata.
+func addStopMetadata(resp *model.LLMResponse, msg *anthropic.Message) {
+	if msg.StopReason == "" {
+		return
+	}
+	setMetadata(resp, StopReasonMetadataKey, string(msg.StopReason))
+	if msg.StopSequence != "" {
+		setMetadata(resp, StopSequenceMetadataKey, msg.StopSequence)
+	}
+	if msg.StopReason != anthropic.StopReasonRefusal {
+		return
+	}
+	if msg.StopDetails.Category != "" {
+		setMetadata(resp, RefusalCategoryMetadataKey, string(msg.StopDetails.Category))
+	}
+	if msg.StopDetails.Explanation != "" {
+		setMetadata(resp, RefusalExplanationMetadataKey, msg.StopDetails.Explanation)
+	}
+}
+
+// setMetadata sets a CustomMetadata entry, allocating the map on first use.
+func setMetadata(resp *model.LLMResponse, key string, value any) {
+	if resp.CustomMetadata == nil {
+		resp.CustomMetadata = make(map[string]any)
+	}
+	resp.CustomMetadata[key] = value
+}
+
 // InterruptedContent holds what could be salvaged from a message whose
 // generation was cut off mid-flight (typically at the max_tokens ceiling). The
 // intact content blocks are converted to genai parts in stream order; the
@@ -77,6 +250,13 @@ type InterruptedContent struct {
 	ToolName     string
 	ToolID       string
 	PartialInput string
+
+	// RepairedArgs is PartialInput repaired into arguments by
+	// RepairPartialJSON, and CompleteKeys lists the top-level keys whose
+	// values were complete before the cut. Both are nil when there is no
+	// truncated tool call or its input could not be repaired.
+	RepairedArgs map[string]any
+	CompleteKeys []string
 }
 
 // SalvageInterruptedMessage extracts the content that survived an interrupted
@@ -103,6 +283,7 @@ func SalvageInterruptedMessage(msg *anthropic.Message) InterruptedContent {
 			out.ToolName = block.Name
 			out.ToolID = block.ID
 			out.PartialInput = string(block.Input)
+			out.RepairedArgs, out.CompleteKeys, _ = RepairPartialJSON(out.PartialInput)
 			continue
 		}
 
@@ -117,9 +298,10 @@ func SalvageInterruptedMessage(msg *anthropic.Message) InterruptedContent {
 }
 
 // HasIncompleteToolInput reports whether any tool_use block in the message
-// carries input that isn't valid JSON — the signature of a tool call cut off
-// mid-generation. Used to detect an interruption even when the SDK accumulator
-// didn't surface an error.
+// carries input that doesn't decode as a JSON object — the signature of a tool
+// call cut off mid-generation. Used to detect an interruption even when the
+// SDK accumulator didn't surface an error, and on non-streaming responses,
+// where no accumulator is involved.
 func HasIncompleteToolInput(msg *anthropic.Message) bool {
 	if msg == nil {
 		return false
@@ -133,11 +315,16 @@ func HasIncompleteToolInput(msg *anthropic.Message) bool {
 }
 
 // isIncompleteToolUse reports whether a content block is a tool_use block whose
-// accumulated input JSON is truncated (not valid JSON). It reads the flattened
-// ContentBlockUnion fields because those are what the SDK accumulator keeps
-// current for an in-progress block.
+// input is truncated: invalid JSON from the stream accumulator, or any value
+// ContentBlockToGenaiPart could not decode into arguments. It reads the
+// flattened ContentBlockUnion fields because those are what the SDK
+// accumulator keeps current for an in-progress block.
 func isIncompleteToolUse(block anthropic.ContentBlockUnion) bool {
-	return block.Type == "tool_use" && !json.Valid(block.Input)
+	if block.Type != "tool_use" {
+		return false
+	}
+	var args map[string]any
+	return json.Unmarshal(block.Input, &args) != nil
 }
 
 // ContentBlockToGenaiPart converts an Anthropic ContentBlockUnion to a genai.Part.
@@ -245,34 +432,99 @@ func webSearchResultToFunctionResponse(block anthropic.WebSearchToolResultBlock)
 	}
 }
 
-// textCitationsToSlice converts Anthropic text citations to a slice of genai.Citation.
-func textCitationsToSlice(citations []anthropic.TextCitationUnion) []*genai.Citation {
-	if len(citations) == 0 {
-		return nil
+// CitationDetail is the Anthropic detail of one citation, which
+// genai.Citation has no room for.
+type CitationDetail struct {
+	// Type is the citation location type: "char_location",
+	// "page_location", "content_block_location",
+	// "web_search_result_location" or "search_result_location".
+	Type string `json:"type"`
+
+	// PartIndex is the index, in the response's Content.Parts, of the text
+	// part making the cited claim.
+	PartIndex int `json:"part_index"`
+
+	// CitedText is the passage of the source the claim rests on.
+	CitedText string `json:"cited_text"`
+
+	// DocumentIndex and DocumentTitle identify the cited document, for the
+	// document location types. DocumentIndex counts the request's document
+	// blocks from zero, in order.
+	DocumentIndex int64  `json:"document_index"`
+	DocumentTitle string `json:"document_title,omitempty"`
+	FileID        string `json:"file_id,omitempty"`
+
+	// Start and End delimit the cited passage in the document; End is
+	// exclusive. Their unit depends on Type: characters for
+	// char_location, 1-based page numbers for page_location, and
+	// content block indices for content_block_location and
+	// search_result_location. Only character ranges are also set on the
+	// genai.Citation, whose indices are character offsets.
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+
+	// SearchResultIndex and Source identify the cited search result, for
+	// search_result_location.
+	SearchResultIndex int64  `json:"search_result_index,omitempty"`
+	Source            string `json:"source,omitempty"`
+
+	// Title and URL identify the cited web page or search result, for
+	// web_search_result_location and search_result_location.
+	Title string `json:"title,omitempty"`
+	URL   string `json:"url,omitempty"`
+}
+
+// textCitationToGenai converts an Anthropic text citation to a
+// genai.Citation. For document locations, StartIndex and EndIndex carry the
+// cited range in the unit of its location type (see CitationDetail).
+func textCitationToGenai(c anthropic.TextCitationUnion) *genai.Citation {
+	citation := &genai.Citation{
+		Title: c.DocumentTitle,
 	}
 
-	result := make([]*genai.Citation, 0, len(citations))
-	for _, c := range citations {
-		citation := &genai.Citation{
-			Title: c.DocumentTitle,
-		}
+	// Map based on citation type. StartIndex and EndIndex are character
+	// offsets; page and content block ranges are reported only in
+	// CitationDetail.
+	switch c.Type {
+	case "char_location":
+		citation.StartIndex = int32(c.StartCharIndex)
+		citation.EndIndex = int32(c.EndCharIndex)
+	case "web_search_result_location":
+		citation.Title = c.Title
+		citation.URI = c.URL
+	case "search_result_location":
+		citation.Title = c.Title
+		citation.URI = c.Source
+	}
 
-		// Map based on citation type
-		switch c.Type {
-		case "char_location":
-			citation.StartIndex = int32(c.StartCharIndex)
-			citation.EndIndex = int32(c.EndCharIndex)
-		case "web_search_result_location":
-			citation.Title = c.Title
-			citation.URI = c.URL
-		case "search_result_location":
-			citation.Title = c.Title
-		}
+	return citation
+}
 
-		result = append(result, citation)
+// textCitationDetail converts an Anthropic text citation made by the text
+// part at partIndex to a CitationDetail.
+func textCitationDetail(c anthropic.TextCitationUnion, partIndex int) CitationDetail {
+	detail := CitationDetail{
+		Type:          c.Type,
+		PartIndex:     partIndex,
+		CitedText:     c.CitedText,
+		DocumentIndex: c.DocumentIndex,
+		DocumentTitle: c.DocumentTitle,
+		FileID:        c.FileID,
 	}
-
-	return result
+	switch c.Type {
+	case "char_location":
+		detail.Start, detail.End = c.StartCharIndex, c.EndCharIndex
+	case "page_location":
+		detail.Start, detail.End = c.StartPageNumber, c.EndPageNumber
+	case "content_block_location":
+		detail.Start, detail.End = c.StartBlockIndex, c.EndBlockIndex
+	case "web_search_result_location":
+		detail.Title, detail.URL = c.Title, c.URL
+	case "search_result_location":
+		detail.Start, detail.End = c.StartBlockIndex, c.EndBlockIndex
+		detail.SearchResultIndex, detail.Source, detail.Title = c.SearchResultIndex, c.Source, c.Title
+	}
+	return detail
 }
 
 // UsageToMetadata converts Anthropic Usage to genai UsageMetadata.
@@ -292,6 +544,12 @@ func UsageToMetadata(usage anthropic.Usage) *genai.GenerateContentResponseUsageM
 }
 
 // StopReasonToFinishReason maps Anthropic StopReason to genai FinishReason.
+//
+// refusal maps to FinishReasonSafety. pause_turn — a long server-tool turn the
+// caller may continue by sending the response back — has no genai
+// equivalent and maps to FinishReasonOther; the raw stop reason is kept under
+// StopReasonMetadataKey to tell it apart. model_context_window_exceeded maps
+// to FinishReasonContextWindowExceeded.
 func StopReasonToFinishReason(sr anthropic.StopReason) genai.FinishReason {
 	switch sr {
 	case anthropic.StopReasonEndTurn:
@@ -302,11 +560,77 @@ func StopReasonToFinishReason(sr anthropic.StopReason) genai.FinishReason {
 		return genai.FinishReasonStop
 	case anthropic.StopReasonToolUse:
 		return genai.FinishReasonStop
+	case anthropic.StopReasonRefusal:
+		return genai.FinishReasonSafety
+	case anthropic.StopReasonPauseTurn:
+		return genai.FinishReasonOther
+	case StopReasonModelContextWindowExceeded:
+		return FinishReasonContextWindowExceeded
 	default:
 		return genai.FinishReasonUnspecified
 	}
 }
 
+// StreamMetadataToPartialResponse builds a content-free partial LLMResponse
+// from the message accumulated so far in a stream. It is emitted on
+// message_start (input and cache token counts, message id, resolved model) and
+// again on message_delta (updated output tokens and stop reason), so consumers
+// can track usage mid-stream — e.g. a budget enforcer cancelling once output
+// passes a limit. Content is left nil: ADK's flow skips content-free responses
+// when building session events, but still runs model callbacks on them.
+func StreamMetadataToPartialResponse(msg *anthropic.Message) *model.LLMResponse {
+	if msg == nil {
+		return nil
+	}
+
+	resp := &model.LLMResponse{
+		UsageMetadata: UsageToMetadata(msg.Usage),
+		ModelVersion:  string(msg.Model),
+		Partial:       true,
+	}
+	if msg.ID != "" {
+		setMetadata(resp, MessageIDMetadataKey, msg.ID)
+	}
+	// message_start carries a null stop_reason; only map one once a
+	// message_delta has supplied it.
+	if msg.StopReason != "" {
+		resp.FinishReason = StopReasonToFinishReason(msg.StopReason)
+	}
+	addStopMetadata(resp, msg)
+	return resp
+}
+
+// StreamBlockStartToPartialResponse returns a content-free partial marking
+// the start of the content block at index with the given type.
+func StreamBlockStartToPartialResponse(index int64, blockType string) *model.LLMResponse {
+	resp := &model.LLMResponse{Partial: true}
+	setMetadata(resp, BlockEventMetadataKey, BlockEventStart)
+	setMetadata(resp, BlockTypeMetadataKey, blockType)
+	SetBlockIndex(resp, index)
+	return resp
+}
+
+// StreamBlockStopToPartialResponse returns a content-free partial marking the
+// end of the content block at index. block is the accumulated block; for a
+// thinking block the marker carries its signature, which is complete only
+// once the block has closed.
+func StreamBlockStopToPartialResponse(index int64, block anthropic.ContentBlockUnion) *model.LLMResponse {
+	resp := &model.LLMResponse{Partial: true}
+	setMetadata(resp, BlockEventMetadataKey, BlockEventStop)
+	setMetadata(resp, BlockTypeMetadataKey, block.Type)
+	SetBlockIndex(resp, index)
+	if block.Type == "thinking" && block.Signature != "" {
+		setMetadata(resp, ThinkingSignatureMetadataKey, block.Signature)
+	}
+	return resp
+}
+
+// SetBlockIndex records the index of the content block a streamed partial
+// belongs to.
+func SetBlockIndex(resp *model.LLMResponse, index int64) {
+	setMetadata(resp, BlockIndexMetadataKey, index)
+}
+
 // StreamDeltaToPartialResponse converts a streaming content block delta to a partial LLMResponse.
 // Used for streaming text updates.
 func StreamDeltaToPartialResponse(text string) *model.LLMResponse {
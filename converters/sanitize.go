@@ -0,0 +1,197 @@
+// Copyright 2026 Alcova AI
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converters
+
+import "github.com/anthropics/anthropic-sdk-go"
+
+// DefaultInterruptedToolResult is the text of the error tool results
+// HistorySanitizer synthesizes for tool calls that never got a response.
+const DefaultInterruptedToolResult = "The tool call was interrupted and did not return a result."
+
+// HistoryRepairAction names a change HistorySanitizer made.
+type HistoryRepairAction string
+
+const (
+	// HistoryRepairSynthesizedResult: a tool call had no response, so an
+	// error tool result was added after it.
+	HistoryRepairSynthesizedResult HistoryRepairAction = "synthesized_result"
+
+	// HistoryRepairDroppedResult: a tool result did not answer a tool call
+	// of the preceding model turn, or answered one twice, and was removed.
+	HistoryRepairDroppedResult HistoryRepairAction = "dropped_result"
+
+	// HistoryRepairMovedResult: a tool result followed other content in
+	// its user turn and was moved to the front.
+	HistoryRepairMovedResult HistoryRepairAction = "moved_result"
+)
+
+// HistoryRepair records one change HistorySanitizer made.
+type HistoryRepair struct {
+	Action HistoryRepairAction
+
+	// ToolUseID is the ID of the tool call or tool result concerned.
+	ToolUseID string
+
+	// ToolName is the name of the tool call, when known. Dropped results
+	// carry none.
+	ToolName string
+
+	// ContentIndex is the index, in the converted contents, of the content
+	// holding the tool call (synthesized results) or the tool result
+	// (dropped and moved results).
+	ContentIndex int
+}
+
+// HistorySanitizer repairs unpaired tool calls and tool results, which
+// Anthropic rejects, e.g. after a cancelled tool run or a crashed session.
+// Every tool call gets a result in the following user turn: a missing one
+// is synthesized as an error result. Tool results that answer no tool call
+// of the preceding model turn are dropped, and the remaining results are
+// moved ahead of any other content of their turn.
+type HistorySanitizer struct {
+	// InterruptedResult is the text of synthesized tool results. Empty
+	// means DefaultInterruptedToolResult.
+	InterruptedResult string
+
+	// OnRepair, when set, is called for every change, in conversation
+	// order, for auditing.
+	OnRepair func(HistoryRepair)
+}
+
+// pendingToolUse is a tool call still waiting for its result.
+type pendingToolUse struct {
+	id, name string
+	source   int
+}
+
+// repair returns messages with their tool calls and results paired up.
+// sources holds the content index of each message, for reporting. It keeps
+// the message boundaries normalizeConsecutiveMessages relies on: results
+// for tool calls that precede a thinking boundary between model messages are
+// inserted at that boundary.
+func (s *HistorySanitizer) repair(messages []anthropic.MessageParam, sources []int) []anthropic.MessageParam {
+	var out []anthropic.MessageParam
+	var pending []pendingToolUse
+	groupThinking := false // whether the current merged model turn has thinking
+
+	for i := 0; i < len(messages); {
+		msg := messages[i]
+		if msg.Role == anthropic.MessageParamRoleAssistant {
+			thinking := hasThinkingBlock(msg.Content)
+			switch {
+			case len(out) == 0 || out[len(out)-1].Role != anthropic.MessageParamRoleAssistant:
+				groupThinking = thinking
+			case groupThinking || thinking:
+				// normalizeConsecutiveMessages splits here, which needs
+				// the tool calls so far answered.
+				if len(pending) > 0 {
+					out = append(out, s.resultsMessage(pending))
+					pending = nil
+				}
+				groupThinking = thinking
+			}
+			for _, block := range msg.Content {
+				if block.OfToolUse != nil {
+					pending = append(pending, pendingToolUse{id: block.OfToolUse.ID, name: block.OfToolUse.Name, source: sources[i]})
+				}
+			}
+			out = append(out, msg)
+			i++
+			continue
+		}
+
+		end := i
+		for end < len(messages) && messages[end].Role == msg.Role {
+			end++
+		}
+		if repaired := s.repairUserTurn(messages[i:end], sources[i:end], pending); repaired != nil {
+			out = append(out, *repaired)
+		}
+		pending = nil
+		i = end
+	}
+	if len(pending) > 0 {
+		out = append(out, s.resultsMessage(pending))
+	}
+	return out
+}
+
+// repairUserTurn merges the consecutive user messages of one turn, keeping
+// only the tool results that answer pending, ahead of the other content, and
+// synthesizing the missing ones. It returns nil when nothing is left.
+func (s *HistorySanitizer) repairUserTurn(turn []anthropic.MessageParam, sources []int, pending []pendingToolUse) *anthropic.MessageParam {
+	expected := make(map[string]bool, len(pending))
+	for _, p := range pending {
+		expected[p.id] = true
+	}
+	answered := make(map[string]bool, len(pending))
+
+	var results, others []anthropic.ContentBlockParamUnion
+	for i, msg := range turn {
+		for _, block := range msg.Content {
+			if block.OfToolResult == nil {
+				others = append(others, block)
+				continue
+			}
+			id := block.OfToolResult.ToolUseID
+			if !expected[id] || answered[id] {
+				s.report(HistoryRepair{Action: HistoryRepairDroppedResult, ToolUseID: id, ContentIndex: sources[i]})
+				continue
+			}
+			answered[id] = true
+			if len(others) > 0 {
+				s.report(HistoryRepair{Action: HistoryRepairMovedResult, ToolUseID: id, ContentIndex: sources[i]})
+			}
+			results = append(results, block)
+		}
+	}
+	for _, p := range pending {
+		if !answered[p.id] {
+			results = append(results, s.interruptedResult(p))
+		}
+	}
+
+	content := append(results, others...)
+	if len(content) == 0 {
+		return nil
+	}
+	return &anthropic.MessageParam{Role: anthropic.MessageParamRoleUser, Content: content}
+}
+
+// resultsMessage returns a user message answering every pending tool call
+// with an error result.
+func (s *HistorySanitizer) resultsMessage(pending []pendingToolUse) anthropic.MessageParam {
+	content := make([]anthropic.ContentBlockParamUnion, 0, len(pending))
+	for _, p := range pending {
+		content = append(content, s.interruptedResult(p))
+	}
+	return anthropic.MessageParam{Role: anthropic.MessageParamRoleUser, Content: content}
+}
+
+// interruptedResult synthesizes the error result of p and reports it.
+func (s *HistorySanitizer) interruptedResult(p pendingToolUse) anthropic.ContentBlockParamUnion {
+	s.report(HistoryRepair{Action: HistoryRepairSynthesizedResult, ToolUseID: p.id, ToolName: p.name, ContentIndex: p.source})
+	text := s.InterruptedResult
+	if text == "" {
+		text = DefaultInterruptedToolResult
+	}
+	return anthropic.NewToolResultBlock(p.id, text, true)
+}
+
+func (s *HistorySanitizer) report(repair HistoryRepair) {
+	if s.OnRepair != nil {
+		s.OnRepair(repair)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2026 Alcova AI
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converters
+
+import (
+	"fmt"
+	"maps"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// SearchResultsResponseKey is the FunctionResponse.Response key whose value
+// is sent as Anthropic search_result blocks inside the tool_result, instead
+// of as JSON text. Build it with SearchResultsResponse. The value is a list
+// of objects with "source", "title" and "content" (a string or a list of
+// strings), the shape SearchResultsResponse produces and that survives a
+// JSON round trip through session storage.
+const SearchResultsResponseKey = "anthropic.search_results"
+
+// SearchResult is a retrieved passage, or several from the same source, that
+// the model can cite.
+type SearchResult struct {
+	// Source identifies where the content came from, typically a URL. It
+	// is returned as the URI of citations of this result.
+	Source string
+
+	// Title names the source.
+	Title string
+
+	// Content holds the passages, each sent as its own text block so
+	// citations can point at them individually.
+	Content []string
+}
+
+// SearchResultsResponse returns a FunctionResponse.Response that converts to
+// one search_result block per result, with citations enabled, so the model
+// can ground its answer in them. The model's citations of these results come
+// back as genai.Citations with the result's title and source URI.
+func SearchResultsResponse(results ...SearchResult) map[string]any {
+	list := make([]any, 0, len(results))
+	for _, r := range results {
+		content := make([]any, 0, len(r.Content))
+		for _, text := range r.Content {
+			content = append(content, text)
+		}
+		list = append(list, map[string]any{
+			"source":  r.Source,
+			"title":   r.Title,
+			"content": content,
+		})
+	}
+	return map[string]any{SearchResultsResponseKey: list}
+}
+
+// splitSearchResults converts the search results in a function response to
+// tool result blocks. It returns the rest of the response, or response itself
+// when it holds no search results.
+func splitSearchResults(response map[string]any) ([]anthropic.ToolResultBlockParamContentUnion, map[string]any, error) {
+	value, ok := response[SearchResultsResponseKey]
+	if !ok {
+		return nil, response, nil
+	}
+
+	var items []any
+	switch v := value.(type) {
+	case []any:
+		items = v
+	case []map[string]any:
+		for _, item := range v {
+			items = append(items, item)
+		}
+	default:
+		return nil, nil, fmt.Errorf("%s must be a list, got %T", SearchResultsResponseKey, value)
+	}
+
+	blocks := make([]anthropic.ToolResultBlockParamContentUnion, 0, len(items))
+	for i, item := range items {
+		block, err := searchResultBlock(item)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid search result %d: %w", i, err)
+		}
+		blocks = append(blocks, anthropic.ToolResultBlockParamContentUnion{OfSearchResult: block})
+	}
+
+	rest := maps.Clone(response)
+	delete(rest, SearchResultsResponseKey)
+	if len(rest) == 0 {
+		rest = nil
+	}
+	return blocks, rest, nil
+}
+
+// searchResultBlock converts one entry of SearchResultsResponseKey.
+func searchResultBlock(item any) (*anthropic.SearchResultBlockParam, error) {
+	fields, ok := item.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("want an object, got %T", item)
+	}
+	source, _ := fields["source"].(string)
+	title, _ := fields["title"].(string)
+	if source == "" || title == "" {
+		return nil, fmt.Errorf("source and title are required")
+	}
+
+	var passages []string
+	switch v := fields["content"].(type) {
+	case string:
+		passages = []string{v}
+	case []string:
+		passages = v
+	case []any:
+		for _, p := range v {
+			text, ok := p.(string)
+			if !ok {
+				return nil, fmt.Errorf("content must hold strings, got %T", p)
+			}
+			passages = append(passages, text)
+		}
+	default:
+		return nil, fmt.Errorf("content must be a string or a list of strings, got %T", v)
+	}
+
+	content := make([]anthropic.TextBlockParam, 0, len(passages))
+	for _, text := range passages {
+		if text != "" {
+			content = append(content, anthropic.TextBlockParam{Text: text})
+		}
+	}
+	if len(content) == 0 {
+		return nil, fmt.Errorf("content is required")
+	}
+	return &anthropic.SearchResultBlockParam{
+		Source:    source,
+		Title:     title,
+		Content:   content,
+		Citations: anthropic.CitationsConfigParam{Enabled: anthropic.Bool(true)},
+	}, nil
+}
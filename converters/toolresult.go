@@ -0,0 +1,123 @@
+// Copyright 2026 Alcova AI
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converters
+
+import (
+	"maps"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"google.golang.org/genai"
+)
+
+// ToolErrorDetector reports whether a function response records a failed
+// tool call, which is then sent as a tool_result with is_error set so the
+// model knows the call failed and can recover.
+type ToolErrorDetector func(resp *genai.FunctionResponse) bool
+
+// DefaultToolErrorDetector recognizes ADK's error convention: a tool that
+// returns an error, or whose arguments fail validation, is reported as
+// {"error": "<message>"}. A response is an error when its "error" key holds
+// anything but nil, an empty string or false.
+func DefaultToolErrorDetector(resp *genai.FunctionResponse) bool {
+	value, ok := resp.Response["error"]
+	if !ok {
+		return false
+	}
+	switch v := value.(type) {
+	case nil:
+		return false
+	case string:
+		return v != ""
+	case bool:
+		return v
+	}
+	return true
+}
+
+// isToolError applies the configured detector, or DefaultToolErrorDetector.
+func (c *conversion) isToolError(resp *genai.FunctionResponse) bool {
+	if c.opts.ToolErrorDetector != nil {
+		return c.opts.ToolErrorDetector(resp)
+	}
+	return DefaultToolErrorDetector(resp)
+}
+
+// ToolResultUnwrapping sends textual tool results as raw text blocks instead
+// of JSON, so long Markdown or prose reaches the model without escaping.
+// Structured results keep the JSON encoding.
+type ToolResultUnwrapping struct {
+	// SingleString sends a response made of a single string value, such
+	// as ADK's {"result": "..."} wrapping of a tool that returns a string,
+	// as that text.
+	SingleString bool
+
+	// Keys lists response keys whose string values are sent as text
+	// blocks, in this order, e.g. "result" and "output". Any other keys in
+	// the same response are sent as JSON ahead of them.
+	Keys []string
+
+	// PartText sends text/* FunctionResponse.Parts as text blocks instead
+	// of plain-text documents. Text blocks cannot carry document
+	// citations.
+	PartText bool
+}
+
+// unwrap splits the text sent raw out of response. It returns the rest of
+// the response, or response itself when nothing is unwrapped. Empty strings
+// are never unwrapped, as Anthropic rejects empty text blocks.
+func (u *ToolResultUnwrapping) unwrap(response map[string]any) ([]string, map[string]any) {
+	if u == nil || len(response) == 0 {
+		return nil, response
+	}
+	if u.SingleString && len(response) == 1 {
+		for _, value := range response {
+			if text, ok := value.(string); ok && text != "" {
+				return []string{text}, nil
+			}
+		}
+	}
+
+	var texts []string
+	rest := response
+	for _, key := range u.Keys {
+		text, ok := response[key].(string)
+		if !ok || text == "" {
+			continue
+		}
+		if texts == nil {
+			rest = maps.Clone(response)
+		}
+		texts = append(texts, text)
+		delete(rest, key)
+	}
+	if texts != nil && len(rest) == 0 {
+		rest = nil
+	}
+	return texts, rest
+}
+
+// partText returns the text of a text/* function response part sent as a
+// text block, or false when the part keeps its default conversion.
+func (u *ToolResultUnwrapping) partText(blob *genai.FunctionResponseBlob) (*anthropic.ToolResultBlockParamContentUnion, bool) {
+	if u == nil || !u.PartText || len(blob.Data) == 0 ||
+		!strings.HasPrefix(baseMediaType(blob.MIMEType), "text/") || !utf8.Valid(blob.Data) {
+		return nil, false
+	}
+	return &anthropic.ToolResultBlockParamContentUnion{
+		OfText: &anthropic.TextBlockParam{Text: string(blob.Data)},
+	}, true
+}
@@ -0,0 +1,248 @@
+// Copyright 2026 Alcova AI
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converters
+
+import (
+	"encoding/json"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// estimatedMediaTokens is the token estimate of an image or document part,
+// about what Anthropic charges for an image at its 1568 px size limit.
+const estimatedMediaTokens = 1600
+
+// HistoryTrimmer shortens a conversation before it is converted, to keep it
+// within the context window. Trim returns the contents to send; it must not
+// modify the contents it keeps.
+//
+// The trimmers in this package drop whole turns only. A turn starts at a user
+// content that carries no tool results (a new prompt) and holds everything up
+// to the next one: model replies, tool calls and their results. Tool calls
+// therefore stay paired with their results, and thinking blocks are never
+// split from their message or modified. System-role contents are always kept.
+type HistoryTrimmer interface {
+	Trim(contents []*genai.Content) []*genai.Content
+}
+
+// HistoryTrimmerFunc adapts a function to HistoryTrimmer.
+type HistoryTrimmerFunc func(contents []*genai.Content) []*genai.Content
+
+// Trim implements HistoryTrimmer.
+func (f HistoryTrimmerFunc) Trim(contents []*genai.Content) []*genai.Content {
+	return f(contents)
+}
+
+// LastTurnsTrimmer keeps the last Turns turns. Zero keeps everything.
+type LastTurnsTrimmer struct {
+	Turns int
+}
+
+// Trim implements HistoryTrimmer.
+func (t LastTurnsTrimmer) Trim(contents []*genai.Content) []*genai.Content {
+	turnOf, turns := segmentTurns(contents)
+	if t.Turns <= 0 || turns <= t.Turns {
+		return contents
+	}
+	first := turns - t.Turns
+	return keepTurns(contents, turnOf, func(turn int) bool { return turn >= first })
+}
+
+// TokenBudgetTrimmer keeps the most recent turns whose estimated size fits
+// MaxTokens, a sliding window over the conversation. The last turn is always
+// kept, even when it alone exceeds the budget.
+type TokenBudgetTrimmer struct {
+	// MaxTokens is the budget for the kept contents, including system-role
+	// contents. Zero keeps everything.
+	MaxTokens int
+
+	// Estimate returns the token estimate of a content. Nil means
+	// EstimateContentTokens.
+	Estimate func(*genai.Content) int
+}
+
+// Trim implements HistoryTrimmer.
+func (t TokenBudgetTrimmer) Trim(contents []*genai.Content) []*genai.Content {
+	turnOf, turns := segmentTurns(contents)
+	if t.MaxTokens <= 0 || turns <= 1 {
+		return contents
+	}
+	estimate := t.Estimate
+	if estimate == nil {
+		estimate = EstimateContentTokens
+	}
+
+	budget := t.MaxTokens
+	sizes := make([]int, turns)
+	for i, content := range contents {
+		if turnOf[i] < 0 {
+			budget -= estimate(content)
+		} else {
+			sizes[turnOf[i]] += estimate(content)
+		}
+	}
+	first := turns - 1
+	budget -= sizes[first]
+	for first > 0 && sizes[first-1] <= budget {
+		first--
+		budget -= sizes[first]
+	}
+	if first == 0 {
+		return contents
+	}
+	return keepTurns(contents, turnOf, func(turn int) bool { return turn >= first })
+}
+
+// KeepFirstTurnTrimmer keeps the first turn, which usually states the task,
+// and trims the rest of the conversation with Tail. Tail sees only the
+// contents after the first turn, so a TokenBudgetTrimmer's budget applies to
+// those alone.
+type KeepFirstTurnTrimmer struct {
+	Tail HistoryTrimmer
+}
+
+// Trim implements HistoryTrimmer.
+func (t KeepFirstTurnTrimmer) Trim(contents []*genai.Content) []*genai.Content {
+	turnOf, turns := segmentTurns(contents)
+	if t.Tail == nil || turns <= 1 {
+		return contents
+	}
+	end := 0
+	for end < len(contents) && turnOf[end] <= 0 {
+		end++
+	}
+	tail := t.Tail.Trim(contents[end:])
+	return append(append(make([]*genai.Content, 0, end+len(tail)), contents[:end]...), tail...)
+}
+
+// segmentTurns assigns each content its turn number, or -1 for system-role
+// contents, which belong to no turn. It returns the number of turns.
+func segmentTurns(contents []*genai.Content) ([]int, int) {
+	turnOf := make([]int, len(contents))
+	turn := -1
+	for i, content := range contents {
+		if content != nil && strings.EqualFold(content.Role, "system") {
+			turnOf[i] = -1
+			continue
+		}
+		if turn < 0 || isPrompt(content) {
+			turn++
+		}
+		turnOf[i] = turn
+	}
+	return turnOf, turn + 1
+}
+
+// isPrompt reports whether content starts a turn: a user content (or one
+// without a role) that carries no tool results.
+func isPrompt(content *genai.Content) bool {
+	if content == nil || (content.Role != "" && !strings.EqualFold(content.Role, genai.RoleUser)) {
+		return false
+	}
+	for _, part := range content.Parts {
+		if part != nil && part.FunctionResponse != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// keepTurns returns the system-role contents and the contents of the turns
+// keep selects, in order.
+func keepTurns(contents []*genai.Content, turnOf []int, keep func(turn int) bool) []*genai.Content {
+	var kept []*genai.Content
+	for i, content := range contents {
+		if turnOf[i] < 0 || keep(turnOf[i]) {
+			kept = append(kept, content)
+		}
+	}
+	return kept
+}
+
+// EstimateContentTokens returns a rough token estimate of content: about four
+// bytes of text or JSON per token, and a fixed estimate per image or
+// document.
+func EstimateContentTokens(content *genai.Content) int {
+	if content == nil {
+		return 0
+	}
+	bytes := 0
+	media := 0
+	for _, part := range content.Parts {
+		switch {
+		case part == nil:
+		case part.InlineData != nil, part.FileData != nil:
+			media++
+		case part.FunctionCall != nil:
+			bytes += len(part.FunctionCall.Name) + jsonSize(part.FunctionCall.Args)
+		case part.FunctionResponse != nil:
+			bytes += jsonSize(part.FunctionResponse.Response)
+			media += len(part.FunctionResponse.Parts)
+		default:
+			bytes += len(part.Text)
+		}
+	}
+	return (bytes+3)/4 + media*estimatedMediaTokens
+}
+
+// jsonSize returns the length of v encoded as JSON, or 0 when it cannot be.
+func jsonSize(v any) int {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+// TrimmedHistory records what a HistoryTrimmer dropped.
+type TrimmedHistory struct {
+	// OriginalContents is the number of contents before trimming.
+	OriginalContents int `json:"original_contents"`
+
+	// DroppedContents lists the indices of the dropped contents in the
+	// original conversation.
+	DroppedContents []int `json:"dropped_contents"`
+
+	// DroppedTokens is the EstimateContentTokens estimate of the dropped
+	// contents.
+	DroppedTokens int `json:"dropped_tokens"`
+}
+
+// TrimContents applies trimmer to contents and reports the contents it
+// dropped, or nil when it dropped none. Kept contents are matched to the
+// originals by identity.
+func TrimContents(trimmer HistoryTrimmer, contents []*genai.Content) ([]*genai.Content, *TrimmedHistory) {
+	if trimmer == nil || len(contents) == 0 {
+		return contents, nil
+	}
+	trimmed := trimmer.Trim(contents)
+
+	kept := make(map[*genai.Content]bool, len(trimmed))
+	for _, content := range trimmed {
+		kept[content] = true
+	}
+	report := &TrimmedHistory{OriginalContents: len(contents)}
+	for i, content := range contents {
+		if !kept[content] {
+			report.DroppedContents = append(report.DroppedContents, i)
+			report.DroppedTokens += EstimateContentTokens(content)
+		}
+	}
+	if len(report.DroppedContents) == 0 {
+		return trimmed, nil
+	}
+	return trimmed, report
+}
@@ -82,7 +82,25 @@
 //   - Extended thinking (mapped to genai.Part with Thought=true)
 //   - Multimodal inputs (text, images)
 //   - PDF document processing (beta)
+//   - Text-like documents as plain-text document blocks, with a
+//     Config.TextExtractor hook for office formats
+//   - Document citations (Config.DocumentCitations, or per part)
+//   - Citable search results from tools (converters.SearchResultsResponse)
+//   - Failed tool calls sent as is_error tool results (Config.ToolErrorDetector)
+//   - Plain-text tool results without JSON wrapping (Config.ToolResultUnwrapping)
+//   - Repair of orphaned tool calls and results in history (Config.HistorySanitizer)
+//   - system, tool and function roles in imported histories (Config.StrictRoles to reject them)
+//   - Assistant prefill (Config.AssistantPrefill, or per request) and configurable continuation prompts
+//   - History trimming by turns or token budget (Config.HistoryTrimmer)
+//   - Context management: clearing of old tool results and thinking (Config.ContextManagement)
 //   - System instructions
 //   - Automatic retry of mid-stream overload errors (streaming only, before
 //     any content has been yielded)
+//   - Optional stream idle watchdog (Config.StreamIdleTimeout,
+//     Config.StreamFirstEventTimeout) that retries or surfaces stalled streams
+//   - Tee, which fans one response stream out to several consumers
+//   - Optional inlining of image and PDF FileData URIs
+//     (Config.FileDataInlining) for backends that reject URL sources
+//   - Optional image normalization to fit Anthropic's image limits
+//     (Config.ImageNormalization)
 package adkanthropic
@@ -15,7 +15,9 @@
 package adkanthropic
 
 import (
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	"google.golang.org/genai"
@@ -55,6 +57,17 @@ type OutputInterruptedError struct {
 	// truncated tool call before the cut. It is not valid JSON.
 	PartialInput string
 
+	// RepairedArgs is a best-effort repair of PartialInput into tool
+	// arguments (see converters.RepairPartialJSON): open strings, arrays
+	// and objects are closed and a dangling key or literal is dropped. Nil
+	// when there is no truncated tool call or its input was unrepairable.
+	RepairedArgs map[string]any
+
+	// CompleteKeys lists the top-level keys of RepairedArgs whose values
+	// were complete before the cut, in input order. Any other key holds a
+	// value that may have been shortened.
+	CompleteKeys []string
+
 	// Cause is the underlying error that surfaced the interruption, if any
 	// (e.g. the SDK accumulator's marshal failure). May be nil when the
 	// interruption was detected directly from the stop reason.
@@ -91,10 +104,27 @@ func newOutputInterruptedError(msg *anthropic.Message, cause error) *OutputInter
 		ToolName:     salvaged.ToolName,
 		ToolID:       salvaged.ToolID,
 		PartialInput: salvaged.PartialInput,
+		RepairedArgs: salvaged.RepairedArgs,
+		CompleteKeys: salvaged.CompleteKeys,
 		Cause:        cause,
 	}
 }
 
+// truncatedToolCallError returns an *OutputInterruptedError when msg stopped
+// at max_tokens with a tool call whose input was cut off, and nil otherwise.
+// Both the streaming and non-streaming paths run this check on the completed
+// message, so callers see the same error whichever transport ADK picked.
+//
+// A max_tokens stop with an otherwise-valid message (e.g. truncated
+// mid-thinking) is NOT an interruption for our purposes — it converts
+// normally and the harness reacts off the mapped max_tokens FinishReason.
+func truncatedToolCallError(msg *anthropic.Message) *OutputInterruptedError {
+	if msg.StopReason != anthropic.StopReasonMaxTokens || !converters.HasIncompleteToolInput(msg) {
+		return nil
+	}
+	return newOutputInterruptedError(msg, nil)
+}
+
 // classifyAccumulateError maps a message.Accumulate failure to the error the
 // stream should surface. Accumulate almost always fails because the SDK
 // re-marshalled a tool call whose input JSON was truncated at the max_tokens
@@ -109,3 +139,124 @@ func classifyAccumulateError(msg *anthropic.Message, cause error) error {
 	}
 	return fmt.Errorf("failed to accumulate message: %w", cause)
 }
+
+// StreamStalledError reports that a streaming response went quiet: no stream
+// event arrived within the configured Config.StreamFirstEventTimeout or
+// Config.StreamIdleTimeout. Keep-alive pings don't count, so a connection that
+// stays open delivering only pings stalls too. A stall before any content was
+// yielded is retried transparently and only surfaces (wrapped as "stream
+// error: ...") once retries are exhausted. A stall after content was yielded is
+// surfaced directly, carrying whatever had been generated so far — the same
+// salvage OutputInterruptedError performs — so callers can decide how to
+// continue.
+type StreamStalledError struct {
+	// Timeout is the limit that elapsed without an event.
+	Timeout time.Duration
+
+	// BeforeFirstEvent is true when the stream stalled before delivering any
+	// event at all (StreamFirstEventTimeout), false for a stall between
+	// events (StreamIdleTimeout).
+	BeforeFirstEvent bool
+
+	// Parts holds the content generated before the stall, converted to genai
+	// parts in stream order, including the text or thinking block that was
+	// still in progress. A tool call whose input was still streaming is NOT
+	// included here — it is exposed as data via ToolName/PartialInput.
+	Parts []*genai.Part
+
+	// ToolName is the name of the tool call whose input was still streaming
+	// when the stream stalled, if any.
+	ToolName string
+
+	// ToolID is the provider-assigned id of that tool call, if any.
+	ToolID string
+
+	// PartialInput is the raw input JSON accumulated for that tool call
+	// before the stall. It is not valid JSON.
+	PartialInput string
+
+	// RepairedArgs and CompleteKeys repair PartialInput into tool
+	// arguments, as on OutputInterruptedError: RepairedArgs is nil when
+	// there is no tool call in progress or its input was unrepairable, and
+	// CompleteKeys lists the top-level keys whose values were complete.
+	RepairedArgs map[string]any
+	CompleteKeys []string
+}
+
+func (e *StreamStalledError) Error() string {
+	if e.BeforeFirstEvent {
+		return fmt.Sprintf("stream stalled: no event within %s of the request", e.Timeout)
+	}
+	return fmt.Sprintf("stream stalled: no event for %s (%d parts salvaged)", e.Timeout, len(e.Parts))
+}
+
+// salvageStalled fills e with the content accumulated before the stall.
+func (e *StreamStalledError) salvageStalled(msg *anthropic.Message) {
+	if msg == nil || len(msg.Content) == 0 {
+		return
+	}
+
+	// The block in progress never received content_block_stop, so the SDK
+	// accumulator hasn't refreshed the backing JSON its AsAny() reads — the
+	// text or thinking streamed so far would be lost. Round-trip it the way
+	// content_block_stop would. A tool call with truncated input can't be
+	// marshalled; leave it for the salvage to report as partial input.
+	salvage := *msg
+	salvage.Content = append([]anthropic.ContentBlockUnion(nil), msg.Content...)
+	last := &salvage.Content[len(salvage.Content)-1]
+	if raw, err := json.Marshal(last); err == nil {
+		var refreshed anthropic.ContentBlockUnion
+		if err := refreshed.UnmarshalJSON(raw); err == nil {
+			*last = refreshed
+		}
+	}
+
+	salvaged := converters.SalvageInterruptedMessage(&salvage)
+	e.Parts = salvaged.Parts
+	e.ToolName = salvaged.ToolName
+	e.ToolID = salvaged.ToolID
+	e.PartialInput = salvaged.PartialInput
+	e.RepairedArgs = salvaged.RepairedArgs
+	e.CompleteKeys = salvaged.CompleteKeys
+}
+
+// RefusalError reports that the model declined to continue: Anthropic stopped
+// the response with stop_reason "refusal". It is only returned when
+// Config.RefusalAsError is set; by default a refusal is an ordinary response
+// with FinishReasonSafety. Any content generated before the refusal is kept
+// in Parts.
+type RefusalError struct {
+	// Category is the policy category that triggered the refusal, if
+	// Anthropic named one.
+	Category anthropic.RefusalStopDetailsCategory
+
+	// Explanation is Anthropic's human-readable explanation, if any. It is
+	// not guaranteed to be stable.
+	Explanation string
+
+	// Parts holds the content generated before the refusal, converted to
+	// genai parts in order.
+	Parts []*genai.Part
+}
+
+func (e *RefusalError) Error() string {
+	switch {
+	case e.Category != "" && e.Explanation != "":
+		return fmt.Sprintf("model refused (category=%s): %s", e.Category, e.Explanation)
+	case e.Category != "":
+		return fmt.Sprintf("model refused (category=%s)", e.Category)
+	default:
+		return "model refused"
+	}
+}
+
+// newRefusalError builds a RefusalError from a message that stopped with a
+// refusal. Blocks that fail conversion are skipped, as in the interruption
+// salvage.
+func newRefusalError(msg *anthropic.Message) *RefusalError {
+	return &RefusalError{
+		Category:    msg.StopDetails.Category,
+		Explanation: msg.StopDetails.Explanation,
+		Parts:       converters.SalvageInterruptedMessage(msg).Parts,
+	}
+}
@@ -17,9 +17,14 @@ package adkanthropic
 import (
 	"encoding/json"
 	"errors"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/anthropics/anthropic-sdk-go"
+	"google.golang.org/adk/v2/model"
+	"google.golang.org/genai"
+
+	"github.com/Alcova-AI/adk-anthropic-go/v2/converters"
 )
 
 // accumulateEvents drives anthropic.Message.Accumulate over a sequence of
@@ -89,6 +94,12 @@ func TestNewOutputInterruptedError_FromAccumulateFailure(t *testing.T) {
 	if err.PartialInput != `{"path": "/reports/summ` {
 		t.Errorf("PartialInput = %q, want the truncated fragment", err.PartialInput)
 	}
+	if got := err.RepairedArgs["path"]; got != "/reports/summ" {
+		t.Errorf("RepairedArgs[path] = %v, want the repaired fragment", got)
+	}
+	if err.CompleteKeys != nil {
+		t.Errorf("CompleteKeys = %q, want none", err.CompleteKeys)
+	}
 	if err.Cause != accErr {
 		t.Errorf("Cause = %v, want the accumulate error", err.Cause)
 	}
@@ -118,6 +129,31 @@ func TestNewOutputInterruptedError_FromAccumulateFailure(t *testing.T) {
 	}
 }
 
+func TestStreamStalledError_SalvageRepairsToolInput(t *testing.T) {
+	// The stream stalls inside the second tool call: everything up to its
+	// last input delta arrived, nothing after.
+	msg, err := accumulateEvents(t, interruptedToolCallStream[:13])
+	if err != nil {
+		t.Fatalf("accumulate: %v", err)
+	}
+
+	stall := &StreamStalledError{}
+	stall.salvageStalled(msg)
+
+	if stall.ToolName != "save_file" || stall.PartialInput != `{"path": "/reports/summ` {
+		t.Errorf("tool = %q, input = %q, want the stalled save_file call", stall.ToolName, stall.PartialInput)
+	}
+	if got := stall.RepairedArgs["path"]; got != "/reports/summ" {
+		t.Errorf("RepairedArgs[path] = %v, want the repaired fragment", got)
+	}
+	if stall.CompleteKeys != nil {
+		t.Errorf("CompleteKeys = %q, want none", stall.CompleteKeys)
+	}
+	if len(stall.Parts) != 3 {
+		t.Errorf("len(Parts) = %d, want 3 (thinking, text, completed tool call)", len(stall.Parts))
+	}
+}
+
 func TestNewOutputInterruptedError_MidThinkingNoToolFields(t *testing.T) {
 	// Cut off mid-thinking, before any tool call: the message is valid JSON so
 	// Accumulate succeeds, but if a caller constructs the typed error from this
@@ -206,3 +242,91 @@ func TestClassifyAccumulateError(t *testing.T) {
 		}
 	})
 }
+
+const refusalMessageJSON = `{"id":"msg_1","type":"message","role":"assistant","model":"claude-haiku-4-5","content":[{"type":"text","text":"I can"}],"stop_reason":"refusal","stop_details":{"type":"refusal","category":"cyber","explanation":"Not allowed"},"usage":{"input_tokens":3,"output_tokens":2}}`
+
+var refusalStream = []string{
+	`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-haiku-4-5","content":[],"stop_reason":null,"usage":{"input_tokens":3,"output_tokens":0}}}`,
+	`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+	`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"I can"}}`,
+	`{"type":"content_block_stop","index":0}`,
+	`{"type":"message_delta","delta":{"stop_reason":"refusal","stop_sequence":null,"stop_details":{"type":"refusal","category":"cyber","explanation":"Not allowed"}},"usage":{"output_tokens":2}}`,
+	`{"type":"message_stop"}`,
+}
+
+func TestRefusal(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		stream         bool
+		refusalAsError bool
+	}{
+		{"generate_response", false, false},
+		{"generate_error", false, true},
+		{"stream_response", true, false},
+		{"stream_error", true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var srv *httptest.Server
+			if tc.stream {
+				srv, _ = newSSEServer(t, sseFromPayloads(t, refusalStream))
+			} else {
+				srv = newJSONServer(t, refusalMessageJSON)
+			}
+			m, _ := newStreamTestModel(t, srv.URL)
+			m.refusalAsError = tc.refusalAsError
+
+			var final *model.LLMResponse
+			var finalErr error
+			for resp, err := range m.GenerateContent(t.Context(), &model.LLMRequest{}, tc.stream) {
+				if err != nil || !resp.Partial {
+					final, finalErr = resp, err
+				}
+			}
+
+			if tc.refusalAsError {
+				var refusal *RefusalError
+				if !errors.As(finalErr, &refusal) {
+					t.Fatalf("err = %v (%T), want *RefusalError", finalErr, finalErr)
+				}
+				if refusal.Category != anthropic.RefusalStopDetailsCategoryCyber || refusal.Explanation != "Not allowed" {
+					t.Errorf("refusal = %+v, want category cyber with explanation", refusal)
+				}
+				if len(refusal.Parts) != 1 || refusal.Parts[0].Text != "I can" {
+					t.Errorf("refusal.Parts = %+v, want the text generated before the refusal", refusal.Parts)
+				}
+				return
+			}
+
+			if finalErr != nil {
+				t.Fatalf("unexpected error: %v", finalErr)
+			}
+			if final.FinishReason != genai.FinishReasonSafety {
+				t.Errorf("FinishReason = %q, want %q", final.FinishReason, genai.FinishReasonSafety)
+			}
+			if got := final.CustomMetadata[converters.RefusalCategoryMetadataKey]; got != "cyber" {
+				t.Errorf("refusal category metadata = %v, want cyber", got)
+			}
+		})
+	}
+}
+
+func TestGenerate_TruncatedToolCallIsInterrupted(t *testing.T) {
+	const body = `{"id":"msg_1","type":"message","role":"assistant","model":"claude-haiku-4-5","content":[{"type":"text","text":"Saving now."},{"type":"tool_use","id":"toolu_cut","name":"write_file","input":"{\"path\": \"/tm"}],"stop_reason":"max_tokens","usage":{"input_tokens":3,"output_tokens":2}}`
+	srv := newJSONServer(t, body)
+	m, _ := newStreamTestModel(t, srv.URL)
+
+	_, err := m.generate(t.Context(), &model.LLMRequest{}, nil)
+	var interrupted *OutputInterruptedError
+	if !errors.As(err, &interrupted) {
+		t.Fatalf("err = %v (%T), want *OutputInterruptedError", err, err)
+	}
+	if interrupted.StopReason != anthropic.StopReasonMaxTokens {
+		t.Errorf("StopReason = %q, want max_tokens", interrupted.StopReason)
+	}
+	if interrupted.ToolName != "write_file" || interrupted.ToolID != "toolu_cut" {
+		t.Errorf("tool = %q/%q, want write_file/toolu_cut", interrupted.ToolName, interrupted.ToolID)
+	}
+	if len(interrupted.Parts) != 1 || interrupted.Parts[0].Text != "Saving now." {
+		t.Errorf("Parts = %+v, want the text generated before the tool call", interrupted.Parts)
+	}
+}
@@ -28,7 +28,11 @@ import (
 
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/google/go-cmp/cmp"
 	"google.golang.org/adk/v2/model"
+	"google.golang.org/genai"
+
+	"github.com/Alcova-AI/adk-anthropic-go/v2/converters"
 )
 
 // Exact shape Vertex delivers when overload arrives after the 200 OK —
@@ -84,6 +88,19 @@ func newSSEServer(t *testing.T, bodies ...string) (*httptest.Server, *atomic.Int
 	return srv, &requests
 }
 
+// newJSONServer answers every request with body as a non-streaming JSON
+// response.
+func newJSONServer(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
 // newStreamTestModel builds a model against baseURL through the real SDK
 // client (so mid-stream error events decode into *anthropic.Error exactly as
 // in production) and stubs retrySleep to record delays without sleeping.
@@ -120,6 +137,20 @@ func collect(ctx context.Context, m *anthropicModel) []streamPair {
 	return pairs
 }
 
+// contentPairs drops the content-free usage/metadata partials emitted on
+// message_start and message_delta, leaving the content deltas, the final
+// response, and errors in order.
+func contentPairs(pairs []streamPair) []streamPair {
+	var out []streamPair
+	for _, p := range pairs {
+		if p.err == nil && p.resp != nil && p.resp.Partial && p.resp.Content == nil {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
 // sseFromPayloads frames raw stream-event payloads (as used by errors_test.go
 // fixtures) into SSE wire format, deriving each event: line from the
 // payload's "type" field.
@@ -156,7 +187,7 @@ func TestGenerateStream_RetriesOverloadThenSucceeds(t *testing.T) {
 			srv, requests := newSSEServer(t, bodies...)
 			m, sleeps := newStreamTestModel(t, srv.URL)
 
-			pairs := collect(t.Context(), m)
+			pairs := contentPairs(collect(t.Context(), m))
 
 			for _, p := range pairs {
 				if p.err != nil {
@@ -219,7 +250,7 @@ func TestGenerateStream_NoRetryAfterPartialOutput(t *testing.T) {
 	srv, requests := newSSEServer(t, partialThenOverloadSSE)
 	m, sleeps := newStreamTestModel(t, srv.URL)
 
-	pairs := collect(t.Context(), m)
+	pairs := contentPairs(collect(t.Context(), m))
 
 	if len(pairs) != 2 {
 		t.Fatalf("len(pairs) = %d, want 2 (partial then error)", len(pairs))
@@ -243,7 +274,7 @@ func TestGenerateStream_NoRetryAfterThinkingOutput(t *testing.T) {
 	srv, requests := newSSEServer(t, thinkingThenOverloadSSE)
 	m, sleeps := newStreamTestModel(t, srv.URL)
 
-	pairs := collect(t.Context(), m)
+	pairs := contentPairs(collect(t.Context(), m))
 
 	if len(pairs) != 2 {
 		t.Fatalf("len(pairs) = %d, want 2 (thinking partial then error)", len(pairs))
@@ -349,7 +380,7 @@ func TestGenerateStream_InterruptedOutputIsNotRetried(t *testing.T) {
 	srv, requests := newSSEServer(t, sseFromPayloads(t, interruptedToolCallStream))
 	m, sleeps := newStreamTestModel(t, srv.URL)
 
-	pairs := collect(t.Context(), m)
+	pairs := contentPairs(collect(t.Context(), m))
 
 	// The fixture streams a thinking delta and a text delta before the
 	// truncated tool call, so those arrive as partials ahead of the error.
@@ -373,6 +404,211 @@ func TestGenerateStream_InterruptedOutputIsNotRetried(t *testing.T) {
 	}
 }
 
+func TestGenerateStream_EmitsUsageMetadataPartials(t *testing.T) {
+	srv, _ := newSSEServer(t, successSSE)
+	m, _ := newStreamTestModel(t, srv.URL)
+
+	pairs := collect(t.Context(), m)
+
+	// message_start, block start, text delta, block stop, message_delta,
+	// final.
+	if len(pairs) != 6 {
+		t.Fatalf("len(pairs) = %d, want 6", len(pairs))
+	}
+	for _, p := range pairs {
+		if p.err != nil {
+			t.Fatalf("unexpected error: %v", p.err)
+		}
+	}
+
+	start := pairs[0].resp
+	if !start.Partial || start.Content != nil {
+		t.Fatalf("pairs[0] = %+v, want content-free partial", start)
+	}
+	if got := start.UsageMetadata.PromptTokenCount; got != 3 {
+		t.Errorf("message_start PromptTokenCount = %d, want 3", got)
+	}
+	if got := start.CustomMetadata[converters.MessageIDMetadataKey]; got != "msg_1" {
+		t.Errorf("message_start message id = %v, want msg_1", got)
+	}
+	if start.ModelVersion != "claude-haiku-4-5" {
+		t.Errorf("message_start ModelVersion = %q, want claude-haiku-4-5", start.ModelVersion)
+	}
+	if start.FinishReason != "" {
+		t.Errorf("message_start FinishReason = %q, want empty before a stop reason arrives", start.FinishReason)
+	}
+
+	delta := pairs[4].resp
+	if !delta.Partial || delta.Content != nil {
+		t.Fatalf("pairs[4] = %+v, want content-free partial", delta)
+	}
+	if got := delta.UsageMetadata.CandidatesTokenCount; got != 2 {
+		t.Errorf("message_delta CandidatesTokenCount = %d, want 2", got)
+	}
+	if delta.FinishReason != genai.FinishReasonStop {
+		t.Errorf("message_delta FinishReason = %q, want %q", delta.FinishReason, genai.FinishReasonStop)
+	}
+}
+
+func TestGenerateStream_EmitsBlockBoundaries(t *testing.T) {
+	srv, _ := newSSEServer(t, sseFromPayloads(t, multiDeltaStream))
+	m, _ := newStreamTestModel(t, srv.URL)
+
+	type blockPartial struct {
+		Event     any
+		Type      any
+		Index     any
+		Text      string
+		Signature any
+	}
+	var got []blockPartial
+	for _, p := range collect(t.Context(), m) {
+		if p.err != nil {
+			t.Fatalf("unexpected error: %v", p.err)
+		}
+		md := p.resp.CustomMetadata
+		if !p.resp.Partial || md[converters.BlockIndexMetadataKey] == nil {
+			continue
+		}
+		bp := blockPartial{
+			Event:     md[converters.BlockEventMetadataKey],
+			Type:      md[converters.BlockTypeMetadataKey],
+			Index:     md[converters.BlockIndexMetadataKey],
+			Signature: md[converters.ThinkingSignatureMetadataKey],
+		}
+		if p.resp.Content != nil {
+			bp.Text = p.resp.Content.Parts[0].Text
+		}
+		got = append(got, bp)
+	}
+
+	want := []blockPartial{
+		{Event: converters.BlockEventStart, Type: "thinking", Index: int64(0)},
+		{Index: int64(0), Text: "weighing "},
+		{Index: int64(0), Text: "options"},
+		{Event: converters.BlockEventStop, Type: "thinking", Index: int64(0), Signature: "c2ln"},
+		{Event: converters.BlockEventStart, Type: "text", Index: int64(1)},
+		{Index: int64(1), Text: "Hello"},
+		{Index: int64(1), Text: ", "},
+		{Index: int64(1), Text: "world"},
+		{Event: converters.BlockEventStop, Type: "text", Index: int64(1)},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("block partials mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// newStallingSSEServer answers the i-th request by writing bodies[i] (an
+// empty body writes nothing, not even headers) and then, for every body but
+// the last, holding the connection open without sending anything more — the
+// shape of a stream that has gone quiet.
+func newStallingSSEServer(t *testing.T, bodies ...string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(requests.Add(1)) - 1
+		if i >= len(bodies) {
+			i = len(bodies) - 1
+		}
+		// Drain the request so the server notices the client hanging up.
+		_, _ = io.Copy(io.Discard, r.Body)
+		if bodies[i] != "" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			_, _ = io.WriteString(w, bodies[i])
+			w.(http.Flusher).Flush()
+		}
+		if i < len(bodies)-1 {
+			<-r.Context().Done()
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestGenerateStream_StallBeforeContentIsRetried(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		stalled    string
+		firstEvent time.Duration
+		idle       time.Duration
+	}{
+		{"no_headers", "", 50 * time.Millisecond, 0},
+		{"after_message_start", messagePrefixSSE, 0, 50 * time.Millisecond},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, requests := newStallingSSEServer(t, tc.stalled, successSSE)
+			m, sleeps := newStreamTestModel(t, srv.URL)
+			m.streamFirstEventTimeout = tc.firstEvent
+			m.streamIdleTimeout = tc.idle
+
+			pairs := contentPairs(collect(t.Context(), m))
+
+			for _, p := range pairs {
+				if p.err != nil {
+					t.Fatalf("unexpected error: %v", p.err)
+				}
+			}
+			if len(pairs) != 2 || pairs[0].resp.Content.Parts[0].Text != "Hello" || !pairs[1].resp.TurnComplete {
+				t.Fatalf("pairs = %+v, want 'Hello' partial then final", pairs)
+			}
+			if got := int(requests.Load()); got != 2 {
+				t.Errorf("requests = %d, want 2 — a pre-content stall must retry", got)
+			}
+			if len(*sleeps) != 1 {
+				t.Errorf("sleeps = %d, want 1", len(*sleeps))
+			}
+		})
+	}
+}
+
+func TestGenerateStream_StallAfterContentSurfacesStalledError(t *testing.T) {
+	stalled := messagePrefixSSE +
+		"event: content_block_delta\n" +
+		"data: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hi\"}}\n\n"
+	srv, requests := newStallingSSEServer(t, stalled, successSSE)
+	m, sleeps := newStreamTestModel(t, srv.URL)
+	m.streamIdleTimeout = 50 * time.Millisecond
+
+	pairs := contentPairs(collect(t.Context(), m))
+
+	if len(pairs) != 2 {
+		t.Fatalf("len(pairs) = %d, want 2 (partial then stall)", len(pairs))
+	}
+	var stall *StreamStalledError
+	if !errors.As(pairs[1].err, &stall) {
+		t.Fatalf("err = %v (%T), want *StreamStalledError", pairs[1].err, pairs[1].err)
+	}
+	if stall.BeforeFirstEvent || stall.Timeout != m.streamIdleTimeout {
+		t.Errorf("stall = %+v, want an idle stall after %v", stall, m.streamIdleTimeout)
+	}
+	// The in-progress text block never saw content_block_stop; its streamed
+	// text must still be salvaged.
+	if len(stall.Parts) != 1 || stall.Parts[0].Text != "Hi" {
+		t.Errorf("stall.Parts = %+v, want the salvaged 'Hi' text", stall.Parts)
+	}
+	if got := int(requests.Load()); got != 1 {
+		t.Errorf("requests = %d, want 1 — a stall after yielded content must not retry", got)
+	}
+	if len(*sleeps) != 0 {
+		t.Errorf("sleeps = %d, want 0", len(*sleeps))
+	}
+}
+
+func TestGenerateStream_SlowConsumerIsNotAStall(t *testing.T) {
+	srv, _ := newSSEServer(t, successSSE)
+	m, _ := newStreamTestModel(t, srv.URL)
+	m.streamFirstEventTimeout = 20 * time.Millisecond
+	m.streamIdleTimeout = 20 * time.Millisecond
+
+	for _, err := range m.GenerateContent(t.Context(), &model.LLMRequest{}, true) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		time.Sleep(60 * time.Millisecond)
+	}
+}
+
 func TestSleepWithContext(t *testing.T) {
 	t.Run("cancel_aborts_promptly", func(t *testing.T) {
 		ctx, cancel := context.WithCancel(t.Context())
@@ -6,6 +6,7 @@ require (
 	github.com/anthropics/anthropic-sdk-go v1.43.0
 	github.com/google/go-cmp v0.7.0
 	github.com/google/jsonschema-go v0.4.2
+	golang.org/x/image v0.25.0
 	google.golang.org/adk/v2 v2.0.0
 	google.golang.org/genai v1.57.0
 )
@@ -0,0 +1,87 @@
+// Copyright 2026 Alcova AI
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adkanthropic
+
+import (
+	"net/http"
+	"strings"
+
+	"google.golang.org/adk/v2/model"
+
+	"github.com/Alcova-AI/adk-anthropic-go/v2/converters"
+)
+
+const rateLimitHeaderPrefix = "anthropic-ratelimit-"
+
+// addResponseMetadata records the backend variant and, when the HTTP response
+// is known, its request id and rate-limit headers in resp.CustomMetadata.
+func (m *anthropicModel) addResponseMetadata(resp *model.LLMResponse, httpResp *http.Response) {
+	if resp.CustomMetadata == nil {
+		resp.CustomMetadata = make(map[string]any)
+	}
+	resp.CustomMetadata[converters.VariantMetadataKey] = m.variant
+	if httpResp == nil {
+		return
+	}
+
+	// Vertex AI fronts the API with its own proxy, which may not forward
+	// request-id; fall back to its x-request-id.
+	requestID := httpResp.Header.Get("request-id")
+	if requestID == "" {
+		requestID = httpResp.Header.Get("x-request-id")
+	}
+	if requestID != "" {
+		resp.CustomMetadata[converters.RequestIDMetadataKey] = requestID
+	}
+
+	rateLimit := make(map[string]string)
+	for name, values := range httpResp.Header {
+		name = strings.ToLower(name)
+		key, ok := strings.CutPrefix(name, rateLimitHeaderPrefix)
+		if !ok || len(values) == 0 {
+			continue
+		}
+		if strings.HasSuffix(key, "-remaining") || strings.HasSuffix(key, "-reset") {
+			rateLimit[key] = values[0]
+		}
+	}
+	if len(rateLimit) > 0 {
+		resp.CustomMetadata[converters.RateLimitMetadataKey] = rateLimit
+	}
+}
+
+// addConvertMetadata records what the request conversion changed, when it
+// changed anything.
+func addConvertMetadata(resp *model.LLMResponse, report *converters.ConvertReport) {
+	if report == nil || len(report.NormalizedImages) == 0 {
+		return
+	}
+	if resp.CustomMetadata == nil {
+		resp.CustomMetadata = make(map[string]any)
+	}
+	resp.CustomMetadata[converters.NormalizedImagesMetadataKey] = report.NormalizedImages
+}
+
+// addTrimMetadata records the contents the history trimmer dropped from the
+// request, if any.
+func addTrimMetadata(resp *model.LLMResponse, trimmed *converters.TrimmedHistory) {
+	if trimmed == nil {
+		return
+	}
+	if resp.CustomMetadata == nil {
+		resp.CustomMetadata = make(map[string]any)
+	}
+	resp.CustomMetadata[converters.TrimmedHistoryMetadataKey] = trimmed
+}
@@ -0,0 +1,138 @@
+// Copyright 2026 Alcova AI
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adkanthropic
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/image/bmp"
+	"google.golang.org/adk/v2/model"
+	"google.golang.org/genai"
+
+	"github.com/Alcova-AI/adk-anthropic-go/v2/converters"
+)
+
+var stopSequenceStream = []string{
+	`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-haiku-4-5","content":[],"stop_reason":null,"usage":{"input_tokens":3,"output_tokens":0}}}`,
+	`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+	`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Done"}}`,
+	`{"type":"content_block_stop","index":0}`,
+	`{"type":"message_delta","delta":{"stop_reason":"stop_sequence","stop_sequence":"END"},"usage":{"output_tokens":1}}`,
+	`{"type":"message_stop"}`,
+}
+
+const stopSequenceMessageJSON = `{"id":"msg_1","type":"message","role":"assistant","model":"claude-haiku-4-5","content":[{"type":"text","text":"Done"}],"stop_reason":"stop_sequence","stop_sequence":"END","usage":{"input_tokens":3,"output_tokens":1}}`
+
+func TestFinalResponseMetadata(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		name := "generate"
+		if stream {
+			name = "stream"
+		}
+		t.Run(name, func(t *testing.T) {
+			body, contentType := stopSequenceMessageJSON, "application/json"
+			if stream {
+				body, contentType = sseFromPayloads(t, stopSequenceStream), "text/event-stream"
+			}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", contentType)
+				w.Header().Set("request-id", "req_123")
+				w.Header().Set("anthropic-ratelimit-requests-limit", "50")
+				w.Header().Set("anthropic-ratelimit-requests-remaining", "49")
+				w.Header().Set("anthropic-ratelimit-tokens-reset", "2026-01-01T00:00:00Z")
+				w.WriteHeader(http.StatusOK)
+				_, _ = io.WriteString(w, body)
+			}))
+			t.Cleanup(srv.Close)
+			m, _ := newStreamTestModel(t, srv.URL)
+
+			var final *model.LLMResponse
+			for resp, err := range m.GenerateContent(t.Context(), &model.LLMRequest{}, stream) {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !resp.Partial {
+					final = resp
+				}
+			}
+
+			want := map[string]any{
+				converters.MessageIDMetadataKey:    "msg_1",
+				converters.RequestIDMetadataKey:    "req_123",
+				converters.StopReasonMetadataKey:   "stop_sequence",
+				converters.StopSequenceMetadataKey: "END",
+				converters.VariantMetadataKey:      VariantAnthropicAPI,
+				converters.RateLimitMetadataKey: map[string]string{
+					"requests-remaining": "49",
+					"tokens-reset":       "2026-01-01T00:00:00Z",
+				},
+			}
+			if diff := cmp.Diff(want, final.CustomMetadata); diff != "" {
+				t.Errorf("CustomMetadata mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFinalResponseMetadata_NormalizedImages(t *testing.T) {
+	var bmpData bytes.Buffer
+	if err := bmp.Encode(&bmpData, image.NewRGBA(image.Rect(0, 0, 4, 2))); err != nil {
+		t.Fatalf("encode BMP: %v", err)
+	}
+
+	for _, stream := range []bool{false, true} {
+		name := "generate"
+		if stream {
+			name = "stream"
+		}
+		t.Run(name, func(t *testing.T) {
+			body, contentType := stopSequenceMessageJSON, "application/json"
+			if stream {
+				body, contentType = sseFromPayloads(t, stopSequenceStream), "text/event-stream"
+			}
+			srv, requests := newRecordingServer(t, contentType, body)
+			m, _ := newStreamTestModel(t, srv.URL)
+			m.imageNormalization = &converters.ImageNormalization{}
+
+			req := &model.LLMRequest{Contents: []*genai.Content{
+				genai.NewContentFromParts([]*genai.Part{genai.NewPartFromBytes(bmpData.Bytes(), "image/bmp")}, genai.RoleUser),
+			}}
+			var final *model.LLMResponse
+			for resp, err := range m.GenerateContent(t.Context(), req, stream) {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !resp.Partial {
+					final = resp
+				}
+			}
+
+			if !bytes.Contains([]byte(requests()[0]), []byte(`"media_type":"image/png"`)) {
+				t.Errorf("request body = %s, want the image sent as PNG", requests()[0])
+			}
+			images, _ := final.CustomMetadata[converters.NormalizedImagesMetadataKey].([]converters.NormalizedImage)
+			if len(images) != 1 || images[0].OriginalMIMEType != "image/bmp" || images[0].MIMEType != "image/png" ||
+				images[0].Width != 4 || images[0].Height != 2 {
+				t.Errorf("normalized images = %+v, want the BMP recorded as a 4x2 PNG", images)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2026 Alcova AI
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adkanthropic
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"sync"
+
+	"google.golang.org/adk/v2/model"
+)
+
+// BackpressurePolicy decides what Tee does when a subscriber's queue is full.
+type BackpressurePolicy int
+
+const (
+	// BackpressureBlock makes the upstream wait until the slowest subscriber
+	// has room, so every subscriber sees every event.
+	BackpressureBlock BackpressurePolicy = iota
+
+	// BackpressureDrop discards partial responses for a subscriber whose
+	// queue is full, so a slow subscriber misses deltas instead of holding
+	// up the others. Final responses and errors are never dropped; they
+	// wait for room as under BackpressureBlock.
+	BackpressureDrop
+
+	// BackpressureBuffer queues without limit, so a slow subscriber never
+	// holds up the others and misses nothing, at the cost of memory.
+	BackpressureBuffer
+)
+
+// TeeConfig configures Tee.
+type TeeConfig struct {
+	// Policy applies when a subscriber's queue is full. Defaults to
+	// BackpressureBlock.
+	Policy BackpressurePolicy
+
+	// BufferSize is the length of each subscriber's queue under
+	// BackpressureBlock and BackpressureDrop. Zero means 1. Ignored by
+	// BackpressureBuffer.
+	BufferSize int
+}
+
+// Tee fans one GenerateContent stream out to n subscribers, so several
+// consumers (a UI, an audit logger, a moderation checker) can read the same
+// model output without each calling the API. generate starts the upstream
+// stream, typically by calling GenerateContent with the context it is given;
+// that context is a child of ctx that Tee cancels to stop the upstream. Tee
+// returns an error if n is negative.
+//
+// The upstream stream starts when the first subscriber begins iterating;
+// events are queued for subscribers that haven't started yet, subject to
+// cfg's policy. A subscriber that stops iterating early is detached and the
+// others keep running. Once every subscriber has stopped, the upstream's
+// context is cancelled, which closes the request even while the upstream is
+// waiting for its next event. ctx bounds how long the upstream waits for
+// room in a full queue: if it ends while waiting, the upstream is stopped and
+// each subscriber receives ctx's error after the events already queued for
+// it.
+//
+// Subscribers share the same *model.LLMResponse values and must not modify
+// them. Each returned sequence may be iterated only once.
+func Tee(ctx context.Context, generate func(context.Context) iter.Seq2[*model.LLMResponse, error], n int, cfg *TeeConfig) ([]iter.Seq2[*model.LLMResponse, error], error) {
+	if n < 0 {
+		return nil, fmt.Errorf("subscriber count must not be negative, got %d", n)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	if cfg == nil {
+		cfg = &TeeConfig{}
+	}
+	capacity := max(cfg.BufferSize, 1)
+
+	upstreamCtx, cancel := context.WithCancel(ctx)
+	t := &tee{ctx: upstreamCtx, cancel: cancel, generate: generate, policy: cfg.Policy, capacity: capacity, active: n}
+	t.subscribers = make([]*teeSubscriber, n)
+	out := make([]iter.Seq2[*model.LLMResponse, error], n)
+	for i := range t.subscribers {
+		sub := &teeSubscriber{
+			wake:  make(chan struct{}, 1),
+			space: make(chan struct{}, 1),
+		}
+		t.subscribers[i] = sub
+		out[i] = func(yield func(*model.LLMResponse, error) bool) {
+			t.start.Do(func() { go t.pump() })
+			sub.consume(t, yield)
+		}
+	}
+	return out, nil
+}
+
+type teeEvent struct {
+	resp *model.LLMResponse
+	err  error
+}
+
+// droppable reports whether BackpressureDrop may discard the event.
+func (e teeEvent) droppable() bool {
+	return e.err == nil && e.resp != nil && e.resp.Partial
+}
+
+type tee struct {
+	ctx         context.Context // the upstream's context
+	cancel      context.CancelFunc
+	generate    func(context.Context) iter.Seq2[*model.LLMResponse, error]
+	policy      BackpressurePolicy
+	capacity    int
+	subscribers []*teeSubscriber
+	start       sync.Once
+
+	mu     sync.Mutex
+	active int
+}
+
+type teeSubscriber struct {
+	mu      sync.Mutex
+	queue   []teeEvent
+	closed  bool // the upstream has ended; no more events will be queued
+	stopped bool // the subscriber stopped iterating
+
+	wake  chan struct{} // signals the subscriber that events or close arrived
+	space chan struct{} // signals the pump that the queue has room
+}
+
+// pump reads the upstream and distributes each event to every subscriber.
+func (t *tee) pump() {
+	defer func() {
+		t.cancel()
+		for _, sub := range t.subscribers {
+			sub.close()
+		}
+	}()
+	for resp, err := range t.generate(t.ctx) {
+		ev := teeEvent{resp: resp, err: err}
+		for _, sub := range t.subscribers {
+			if !t.send(sub, ev) {
+				t.fail(t.ctx.Err())
+				return
+			}
+		}
+		if t.allStopped() {
+			return
+		}
+	}
+}
+
+// send queues ev for sub according to the policy. It returns false if ctx
+// ended while waiting for room.
+func (t *tee) send(sub *teeSubscriber, ev teeEvent) bool {
+	for {
+		sub.mu.Lock()
+		switch {
+		case sub.stopped:
+			sub.mu.Unlock()
+			return true
+		case t.policy == BackpressureBuffer || len(sub.queue) < t.capacity:
+			sub.queue = append(sub.queue, ev)
+			sub.mu.Unlock()
+			signal(sub.wake)
+			return true
+		case t.policy == BackpressureDrop && ev.droppable():
+			sub.mu.Unlock()
+			return true
+		}
+		sub.mu.Unlock()
+
+		select {
+		case <-sub.space:
+		case <-t.ctx.Done():
+			return false
+		}
+	}
+}
+
+// fail queues err for every subscriber still listening, regardless of room.
+func (t *tee) fail(err error) {
+	for _, sub := range t.subscribers {
+		sub.mu.Lock()
+		if !sub.stopped {
+			sub.queue = append(sub.queue, teeEvent{err: err})
+		}
+		sub.mu.Unlock()
+	}
+}
+
+func (t *tee) allStopped() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.active == 0
+}
+
+// consume yields queued events to the subscriber until the upstream ends or
+// the subscriber stops.
+func (sub *teeSubscriber) consume(t *tee, yield func(*model.LLMResponse, error) bool) {
+	for {
+		sub.mu.Lock()
+		if len(sub.queue) > 0 {
+			ev := sub.queue[0]
+			sub.queue[0] = teeEvent{}
+			sub.queue = sub.queue[1:]
+			sub.mu.Unlock()
+			signal(sub.space)
+			if !yield(ev.resp, ev.err) {
+				sub.stop(t)
+				return
+			}
+			continue
+		}
+		closed := sub.closed
+		sub.mu.Unlock()
+		if closed {
+			return
+		}
+		<-sub.wake
+	}
+}
+
+// stop detaches the subscriber: its queue is dropped and the pump no longer
+// waits on it.
+func (sub *teeSubscriber) stop(t *tee) {
+	sub.mu.Lock()
+	sub.stopped = true
+	sub.queue = nil
+	sub.mu.Unlock()
+	signal(sub.space)
+
+	t.mu.Lock()
+	t.active--
+	last := t.active == 0
+	t.mu.Unlock()
+	if last {
+		// Nobody is listening: close the upstream request now rather than
+		// at its next event, which a stalled stream may never send.
+		t.cancel()
+	}
+}
+
+func (sub *teeSubscriber) close() {
+	sub.mu.Lock()
+	sub.closed = true
+	sub.mu.Unlock()
+	signal(sub.wake)
+}
+
+// signal wakes the receiver of a one-slot notification channel without
+// blocking if a notification is already pending.
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2026 Alcova AI
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adkanthropic
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/adk/v2/model"
+	"google.golang.org/genai"
+)
+
+// fakeUpstream yields partials partial responses and a final one, counting
+// how many it produced before its consumer stopped it.
+func fakeUpstream(partials int, produced *atomic.Int32) func(context.Context) iter.Seq2[*model.LLMResponse, error] {
+	return func(context.Context) iter.Seq2[*model.LLMResponse, error] {
+		return func(yield func(*model.LLMResponse, error) bool) {
+			for i := range partials {
+				produced.Add(1)
+				resp := &model.LLMResponse{Content: genai.NewContentFromText(strconv.Itoa(i), genai.RoleModel), Partial: true}
+				if !yield(resp, nil) {
+					return
+				}
+			}
+			produced.Add(1)
+			yield(&model.LLMResponse{Content: genai.NewContentFromText("final", genai.RoleModel), TurnComplete: true}, nil)
+		}
+	}
+}
+
+func mustTee(t *testing.T, ctx context.Context, generate func(context.Context) iter.Seq2[*model.LLMResponse, error], n int, cfg *TeeConfig) []iter.Seq2[*model.LLMResponse, error] {
+	t.Helper()
+	subs, err := Tee(ctx, generate, n, cfg)
+	if err != nil {
+		t.Fatalf("Tee: %v", err)
+	}
+	return subs
+}
+
+type teeResult struct {
+	texts []string
+	err   error
+}
+
+func drainTee(seq iter.Seq2[*model.LLMResponse, error], stopAfter int) teeResult {
+	var res teeResult
+	for resp, err := range seq {
+		if err != nil {
+			res.err = err
+			continue
+		}
+		res.texts = append(res.texts, resp.Content.Parts[0].Text)
+		if len(res.texts) == stopAfter {
+			break
+		}
+	}
+	return res
+}
+
+func TestTee_BlockDeliversEverythingToEverySubscriber(t *testing.T) {
+	var produced atomic.Int32
+	subs := mustTee(t, t.Context(), fakeUpstream(5, &produced), 3, nil)
+
+	results := make([]teeResult, len(subs))
+	var wg sync.WaitGroup
+	for i, sub := range subs {
+		wg.Go(func() { results[i] = drainTee(sub, -1) })
+	}
+	wg.Wait()
+
+	for i, res := range results {
+		if len(res.texts) != 6 || res.texts[5] != "final" {
+			t.Errorf("subscriber %d got %q, want 5 partials and the final response", i, res.texts)
+		}
+	}
+}
+
+func TestTee_StoppedSubscriberDoesNotStopOthers(t *testing.T) {
+	var produced atomic.Int32
+	subs := mustTee(t, t.Context(), fakeUpstream(5, &produced), 2, nil)
+
+	var early, full teeResult
+	var wg sync.WaitGroup
+	wg.Go(func() { early = drainTee(subs[0], 1) })
+	wg.Go(func() { full = drainTee(subs[1], -1) })
+	wg.Wait()
+
+	if len(early.texts) != 1 {
+		t.Errorf("early subscriber got %q, want exactly one event", early.texts)
+	}
+	if len(full.texts) != 6 {
+		t.Errorf("remaining subscriber got %q, want all 6 events", full.texts)
+	}
+	if got := produced.Load(); got != 6 {
+		t.Errorf("upstream produced %d events, want all 6", got)
+	}
+}
+
+func TestTee_UpstreamStopsWhenAllSubscribersStop(t *testing.T) {
+	var produced atomic.Int32
+	subs := mustTee(t, t.Context(), fakeUpstream(100, &produced), 2, nil)
+
+	var wg sync.WaitGroup
+	for _, sub := range subs {
+		wg.Go(func() { drainTee(sub, 2) })
+	}
+	wg.Wait()
+
+	// Blocking queues keep the upstream at most a few events ahead, and the
+	// pump stops it once the last subscriber stops.
+	if got := produced.Load(); got > 5 {
+		t.Errorf("upstream produced %d events, want it stopped early", got)
+	}
+}
+
+func TestTee_SlowSubscriberPolicies(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		cfg      *TeeConfig
+		wantSlow []string
+	}{
+		// The slow queue keeps the first partial; later partials are
+		// dropped, but the final response waits for room.
+		{"drop", &TeeConfig{Policy: BackpressureDrop, BufferSize: 1}, []string{"0", "final"}},
+		{"buffer", &TeeConfig{Policy: BackpressureBuffer}, []string{"0", "1", "2", "3", "4", "final"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var produced atomic.Int32
+			subs := mustTee(t, t.Context(), fakeUpstream(5, &produced), 2, tc.cfg)
+
+			// The slow subscriber reads nothing until the upstream has
+			// produced every event, which only happens if it never holds
+			// up the fast one.
+			var fast teeResult
+			var wg sync.WaitGroup
+			wg.Go(func() { fast = drainTee(subs[0], -1) })
+			for produced.Load() < 6 {
+				time.Sleep(time.Millisecond)
+			}
+			slow := drainTee(subs[1], -1)
+			wg.Wait()
+
+			if len(fast.texts) == 0 || fast.texts[len(fast.texts)-1] != "final" {
+				t.Errorf("fast subscriber got %q, want it to end with the final response", fast.texts)
+			}
+			if !slices.Equal(slow.texts, tc.wantSlow) {
+				t.Errorf("slow subscriber got %q, want %q", slow.texts, tc.wantSlow)
+			}
+		})
+	}
+}
+
+func TestTee_ContextEndsBlockedUpstream(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	var produced atomic.Int32
+	subs := mustTee(t, ctx, fakeUpstream(5, &produced), 2, nil)
+
+	// subs[1] never reads, so the pump blocks once its queue is full.
+	var res teeResult
+	done := make(chan struct{})
+	go func() {
+		res = drainTee(subs[0], -1)
+		close(done)
+	}()
+	cancel()
+	<-done
+
+	if !errors.Is(res.err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled after the queued events", res.err)
+	}
+	if len(res.texts) == 6 {
+		t.Error("subscriber got every event; want the upstream stopped by the cancelled context")
+	}
+}
+
+func TestTee_StalledUpstreamIsCancelledWhenAllSubscribersStop(t *testing.T) {
+	cancelled := make(chan struct{})
+	stalled := func(ctx context.Context) iter.Seq2[*model.LLMResponse, error] {
+		return func(yield func(*model.LLMResponse, error) bool) {
+			if !yield(&model.LLMResponse{Content: genai.NewContentFromText("0", genai.RoleModel), Partial: true}, nil) {
+				return
+			}
+			// A stream that has gone quiet: no further event until the
+			// request is cancelled.
+			<-ctx.Done()
+			close(cancelled)
+			yield(nil, ctx.Err())
+		}
+	}
+	subs := mustTee(t, t.Context(), stalled, 2, nil)
+
+	var wg sync.WaitGroup
+	for _, sub := range subs {
+		wg.Go(func() { drainTee(sub, 1) })
+	}
+	wg.Wait()
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream context not cancelled after every subscriber stopped")
+	}
+}
+
+func TestTee_NegativeCount(t *testing.T) {
+	if _, err := Tee(t.Context(), fakeUpstream(1, new(atomic.Int32)), -1, nil); err == nil {
+		t.Error("Tee succeeded with n = -1, want an error")
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2026 Alcova AI
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adkanthropic
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// streamWatchdog cancels a streaming request that goes quiet. It is armed
+// only while the stream is blocked waiting for its next event and disarmed
+// while the consumer handles a yielded response, so a slow consumer is never
+// mistaken for a stalled server. The SDK swallows keep-alive pings inside
+// Next(), so every event that wakes the stream is a meaningful one.
+type streamWatchdog struct {
+	firstEvent time.Duration
+	idle       time.Duration
+	cancel     context.CancelCauseFunc
+
+	timer     *time.Timer
+	seenEvent bool
+}
+
+// newStreamWatchdog returns a watchdog that stalls the stream via cancel,
+// with a *StreamStalledError as the cancellation cause. A zero timeout
+// disables the corresponding phase.
+func newStreamWatchdog(cancel context.CancelCauseFunc, firstEvent, idle time.Duration) *streamWatchdog {
+	return &streamWatchdog{firstEvent: firstEvent, idle: idle, cancel: cancel}
+}
+
+// next waits for the stream's next event with the watchdog armed.
+func (w *streamWatchdog) next(advance func() bool) bool {
+	w.arm()
+	defer w.disarm()
+	return advance()
+}
+
+// arm starts the timer for the current phase unless it is already running,
+// so arming before the request is sent covers the wait for response headers
+// as well as the first event.
+func (w *streamWatchdog) arm() {
+	if w.timer != nil {
+		return
+	}
+	timeout := w.idle
+	if !w.seenEvent {
+		timeout = w.firstEvent
+	}
+	if timeout <= 0 {
+		return
+	}
+	stalled := &StreamStalledError{Timeout: timeout, BeforeFirstEvent: !w.seenEvent}
+	w.timer = time.AfterFunc(timeout, func() { w.cancel(stalled) })
+}
+
+func (w *streamWatchdog) disarm() {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	w.seenEvent = true
+}
+
+// stalledCause returns the watchdog's stall error if it is what cancelled
+// streamCtx. A cancellation of the caller's own context is not a stall.
+func stalledCause(ctx, streamCtx context.Context) *StreamStalledError {
+	if ctx.Err() != nil {
+		return nil
+	}
+	var stalled *StreamStalledError
+	if errors.As(context.Cause(streamCtx), &stalled) {
+		return stalled
+	}
+	return nil
+}